@@ -1,10 +1,17 @@
 package handler
 
 import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"go_excelize/internal/app/model"
 	"go_excelize/internal/app/service"
+	"io"
 	"math"
 	"math/rand"
+	"mime"
 	"net/http"
 	"strconv"
 	"strings"
@@ -20,289 +27,6 @@ func NewExcelHandler(s *service.ExcelService) *ExcelHandler {
 	return &ExcelHandler{service: s}
 }
 
-// Pass the text in, but not the cell dimensions.
-func newFlowchartShape(cell, shapeType string, width, height, cellPadding uint) *excelize.Shape {
-	lineWidth := 1.2
-	return &excelize.Shape{
-		Cell: cell,
-		Type: shapeType,
-		Line: excelize.ShapeLine{Color: "060270", Width: &lineWidth},
-		Fill: excelize.Fill{Color: []string{"FFFFFF"}, Pattern: 1},
-		Paragraph: []excelize.RichTextRun{
-			{
-				Font: &excelize.Font{
-					Bold:   false,
-					Italic: false,
-					Family: "Times New Roman",
-					Size:   14,
-					Color:  "777777",
-				},
-			},
-		},
-		Width:  width,
-		Height: height,
-		Format: excelize.GraphicOptions{
-			Positioning: "oneCell", // "Move but do not size with cells"
-			OffsetX:     int(cellPadding),
-			OffsetY:     int(cellPadding),
-		},
-	}
-}
-
-func newArrowShape(cell, originPos, targetPos, orientation string, shapeWidth, shapeHeight, cellWidth, cellHeight float64, arrowLength int) (*excelize.Shape, *excelize.Shape, *excelize.Shape) {
-	// lineWidth := 0.25
-	lineWidth := 1.5
-	// line := &excelize.Shape{
-	// 	Cell: cell,
-	// 	Line: excelize.ShapeLine{Color: "060270", Width: &lineWidth},
-	// 	Fill: excelize.Fill{Color: []string{"060270"}, Pattern: 1},
-	// }
-
-	// arrowHead := &excelize.Shape{
-	// 	Line: excelize.ShapeLine{Color: "060270", Width: &lineWidth},
-	// 	Fill: excelize.Fill{Color: []string{"060270"}, Pattern: 1},
-	// }
-	line := &excelize.Shape{
-		Cell: cell,
-		Line: excelize.ShapeLine{Color: "000000", Width: &lineWidth},
-		Fill: excelize.Fill{Color: []string{"000000"}, Pattern: 1},
-	}
-
-	line2 := &excelize.Shape{
-		Cell: cell,
-		Line: excelize.ShapeLine{Color: "000000", Width: &lineWidth},
-		Fill: excelize.Fill{Color: []string{"000000"}, Pattern: 1},
-	}
-
-	arrowHead := &excelize.Shape{
-		Line: excelize.ShapeLine{Color: "000000", Width: &lineWidth},
-		Fill: excelize.Fill{Color: []string{"000000"}, Pattern: 1},
-	}
-
-	// widthDiff is now SIGNED. e.g., -1 for left, +1 for right.
-	widthDiff, heightDiff, err := getCellDifference(originPos, targetPos)
-	if err != nil {
-		fmt.Println("Error:", err)
-	}
-
-	colNum, rowNum, err := excelize.CellNameToCoordinates(cell)
-	if err != nil {
-		fmt.Println("Error:", err)
-	}
-
-	oriColNum, oriRowNum, err := excelize.CellNameToCoordinates(originPos)
-	if err != nil {
-		fmt.Println("Error:", err)
-	}
-
-	// Use absolute values for width/height calculations
-	absWidthDiff := math.Abs(float64(widthDiff))
-	absHeightDiff := math.Abs(float64(heightDiff))
-
-	switch orientation {
-	case "downConn":
-		arrowX := cellWidth / 2
-		arrowY := (cellHeight-shapeHeight)/2 + shapeHeight - (cellHeight-shapeHeight)/2 // - 5 is added because for some reason there were a down offset
-
-		line.Type = "downArrow"
-		line.Width = 1
-		line.Height = uint(arrowLength)
-		line.Format = excelize.GraphicOptions{
-			OffsetX: int(arrowX),
-			OffsetY: int(arrowY),
-		}
-
-		arrowHead = nil
-
-	case "rightConn":
-		arrowX := (cellWidth-shapeWidth)/2 + shapeWidth - (cellWidth-shapeWidth)/2
-		arrowY := cellHeight / 2
-		// arrowWidth := (cellWidth-shapeWidth)/2 + cellWidth*(absWidthDiff-1) + cellWidth/2 + (cellWidth - shapeWidth) // add 1 padding
-		arrowWidth := (cellWidth-shapeWidth)*absWidthDiff + cellWidth*(absWidthDiff) - cellWidth/2
-		arrowHeight := (cellHeight-shapeHeight)/2 + cellHeight*(absHeightDiff-1) + cellHeight/2
-
-		line.Type = "rect"
-		line.Cell = cell
-		line.Width = uint(arrowWidth)
-		line.Height = 1
-		line.Format = excelize.GraphicOptions{
-			OffsetX: int(arrowX),
-			OffsetY: int(arrowY),
-		}
-
-		arrowHead.Type = "downArrow"
-		arrowHead.Cell = fmt.Sprintf("%c%d", 'A'+(colNum+widthDiff-1), rowNum)
-		arrowHead.Width = 1
-		arrowHead.Height = uint(arrowHeight)
-		arrowHead.Format = excelize.GraphicOptions{
-			OffsetX: int(cellWidth) / 2,
-			OffsetY: int(cellHeight) / 2,
-		}
-	case "downRightConn":
-		arrowX := cellWidth / 2
-		arrowWidth := cellWidth*(absWidthDiff) + ((cellWidth - shapeWidth) / 2 * absWidthDiff)
-		// arrowHeight := (cellHeight-shapeWidth)/2 + cellHeight*(absHeightDiff-1) + cellHeight/2
-
-		line.Type = "rect"
-		line.Cell = cell
-		line.Width = 1
-		line.Height = uint(cellHeight-shapeHeight) / 2
-		line.Format = excelize.GraphicOptions{
-			OffsetX: int(arrowX),
-			OffsetY: int(cellHeight - (cellHeight - shapeHeight)),
-		}
-
-		line2.Type = "rect"
-		line2.Cell = fmt.Sprintf("%c%d", 'A'+colNum-1, rowNum+widthDiff)
-		line2.Width = uint(arrowWidth)
-		line2.Height = 1
-		line2.Format = excelize.GraphicOptions{
-			OffsetX: int(arrowX),
-			// OffsetY: int(cellHeight - 1),
-		}
-
-		arrowHead.Type = "downArrow"
-		arrowHead.Cell = targetPos
-		arrowHead.Width = 1
-		arrowHead.Height = uint(cellHeight-shapeHeight) / 2
-		arrowHead.Format = excelize.GraphicOptions{
-			OffsetX: int(arrowX),
-			// OffsetY: int(cellHeight) / 2,
-		}
-	case "leftConn":
-		arrowX := cellWidth / 2
-		arrowY := cellHeight / 2
-		// arrowWidth := (cellWidth-shapeWidth)/2 + cellWidth*(absWidthDiff-1) + cellWidth/2 + (cellWidth - shapeWidth)
-		arrowWidth := (cellWidth-shapeWidth)*absWidthDiff + cellWidth*(absWidthDiff) - cellWidth/2
-		arrowHeight := (cellHeight-shapeHeight)/2 + cellHeight*(absHeightDiff-1) + cellHeight/2
-
-		line.Type = "rect"
-		line.Cell = fmt.Sprintf("%c%d", 'A'+(oriColNum+widthDiff-1), oriRowNum)
-		line.Width = uint(arrowWidth)
-		line.Height = 1
-		line.Format = excelize.GraphicOptions{
-			OffsetX: int(arrowX),
-			OffsetY: int(arrowY),
-		}
-
-		arrowHead.Type = "downArrow"
-		// --- FIX: Anchor to target cell ---
-		arrowHead.Cell = fmt.Sprintf("%c%d", 'A'+(oriColNum+widthDiff-1), oriRowNum)
-		arrowHead.Width = 1
-		arrowHead.Height = uint(arrowHeight)
-		arrowHead.Format = excelize.GraphicOptions{
-			OffsetX: int(cellWidth) / 2,
-			OffsetY: int(cellHeight) / 2,
-		}
-	case "downLeftConn":
-		arrowX := cellWidth / 2
-		arrowWidth := cellWidth*(absWidthDiff) + ((cellWidth - shapeWidth) / 2 * absWidthDiff)
-		// arrowHeight := (cellHeight-shapeWidth)/2 + cellHeight*(absHeightDiff-1) + cellHeight/2
-
-		line.Type = "rect"
-		line.Cell = originPos
-		line.Width = 1
-		line.Height = uint(cellHeight-shapeHeight) / 2
-		line.Format = excelize.GraphicOptions{
-			OffsetX: int(arrowX),
-			OffsetY: int(cellHeight - (cellHeight - shapeHeight)),
-		}
-
-		line2.Type = "rect"
-		line2.Cell = targetPos
-		line2.Width = uint(arrowWidth)
-		line2.Height = 1
-		line2.Format = excelize.GraphicOptions{
-			OffsetX: int(arrowX),
-			// OffsetY: int(cellHeight - 1),
-		}
-
-		arrowHead.Type = "downArrow"
-		arrowHead.Cell = targetPos
-		arrowHead.Width = 1
-		arrowHead.Height = uint(cellHeight-shapeHeight) / 2
-		arrowHead.Format = excelize.GraphicOptions{
-			OffsetX: int(arrowX),
-			// OffsetY: int(cellHeight) / 2,
-		}
-	case "upperRightConn":
-		arrowX := (cellWidth-shapeWidth)/4 + shapeWidth
-		arrowY := cellHeight / 2
-		arrowWidth := (cellWidth-shapeWidth)*absWidthDiff + cellWidth*(absWidthDiff)
-		arrowHeight := cellHeight*(absHeightDiff) - ((cellHeight - shapeHeight) / 2 * absHeightDiff)
-
-		fmt.Printf("upperRightConn absWidth = %v, absHeight = %v\n", absWidthDiff, absHeightDiff)
-
-		if arrowWidth == 0 {
-			arrowWidth = cellWidth - shapeWidth
-		}
-
-		line.Type = "rect"
-		line.Cell = cell
-		line.Width = uint(arrowWidth)
-		line.Height = 1
-		line.Format = excelize.GraphicOptions{
-			OffsetX: int(arrowX),
-			OffsetY: int(arrowY),
-		}
-
-		line2.Type = "rect"
-		line2.Cell = targetPos
-		line2.Width = 1
-		line2.Height = uint(arrowHeight)
-		line2.Format = excelize.GraphicOptions{
-			OffsetX: int(cellWidth),
-			OffsetY: int(arrowY),
-		}
-
-		arrowHead.Type = "leftArrow"
-		arrowHead.Cell = targetPos
-		arrowHead.Width = uint(cellWidth - shapeWidth)
-		arrowHead.Height = 1
-		arrowHead.Format = excelize.GraphicOptions{
-			OffsetX: int(arrowX),
-			OffsetY: int(cellHeight / 2),
-		}
-	case "upperLeftConn":
-		// arrowX := cellWidth / 2
-		arrowY := cellHeight / 2
-		arrowWidth := (cellWidth-shapeWidth)*absWidthDiff + cellWidth*(absWidthDiff)
-		arrowHeight := cellHeight*(absHeightDiff) - ((cellHeight - shapeHeight) / 2 * absHeightDiff)
-
-		if arrowWidth == 0 {
-			arrowWidth = cellWidth - shapeWidth
-		}
-
-		line.Type = "rect"
-		line.Cell = fmt.Sprintf("%c%d", 'A'+(oriColNum+widthDiff-1), oriRowNum)
-		line.Width = uint(arrowWidth)
-		line.Height = 1
-		line.Format = excelize.GraphicOptions{
-			// OffsetX: int(arrowX),
-			OffsetY: int(arrowY),
-		}
-
-		line2.Type = "rect"
-		line2.Cell = targetPos
-		line2.Width = 1
-		line2.Height = uint(arrowHeight)
-		line2.Format = excelize.GraphicOptions{
-			// OffsetX: int(arrowX),
-			OffsetY: int(arrowY),
-		}
-
-		arrowHead.Type = "rightArrow"
-		arrowHead.Cell = targetPos
-		arrowHead.Width = uint(cellWidth - shapeWidth)
-		arrowHead.Height = 1
-		arrowHead.Format = excelize.GraphicOptions{
-			// OffsetX: int(cellWidth) / 2,
-			OffsetY: int(arrowY),
-		}
-	}
-	return line, line2, arrowHead
-}
-
 // --- REMOVED findCellByOrder function ---
 
 // --- NEW HELPER FUNCTION ---
@@ -336,18 +60,37 @@ func (h *ExcelHandler) GenerateExcel(w http.ResponseWriter, r *http.Request) {
 	orderParam := r.URL.Query().Get("orders")
 	trueBranchesParam := r.URL.Query().Get("true_branches")
 	falseBranchesParam := r.URL.Query().Get("false_branches")
+	branchesParam := r.URL.Query().Get("branches")
 	shapeWidthParam := r.URL.Query().Get("width")
 	shapeHeightParam := r.URL.Query().Get("height")
 	cellPadParam := r.URL.Query().Get("pad")
 	gapParam := r.URL.Query().Get("gap")
-
-	if shapesParam == "" || startCellParam == "" || orderParam == "" || shapeWidthParam == "" || shapeHeightParam == "" || gapParam == "" || cellPadParam == "" {
+	labelsParam := r.URL.Query().Get("labels")
+	actorsParam := r.URL.Query().Get("actors")
+	autoShrinkParam := r.URL.Query().Get("auto_shrink")
+	autoSizeParam := r.URL.Query().Get("auto_size")
+	resizeCellsParam := r.URL.Query().Get("resize_cells")
+	groupParam := r.URL.Query().Get("group")
+	withTerminatorParam := r.URL.Query().Get("with_terminator")
+	fontSizeParam := r.URL.Query().Get("font_size")
+	layoutParam := r.URL.Query().Get("layout")
+	langParam := r.URL.Query().Get("lang")
+	directionParam := r.URL.Query().Get("direction")
+
+	// orders can be left out when the layout is computed automatically
+	ordersRequired := layoutParam != model.LayoutAuto
+	if shapesParam == "" || startCellParam == "" || (orderParam == "" && ordersRequired) || shapeWidthParam == "" || shapeHeightParam == "" || gapParam == "" || cellPadParam == "" {
 		http.Error(w, "Please provide all required parameters.", http.StatusBadRequest)
 		return
 	}
 
 	shapeTypes := strings.Split(shapesParam, ",")
-	orderFlows := strings.Split(orderParam, ",")
+	var orderFlows []string
+	if orderParam != "" {
+		orderFlows = strings.Split(orderParam, ",")
+	} else {
+		orderFlows = make([]string, len(shapeTypes))
+	}
 	shapeWidth, _ := strconv.Atoi(shapeWidthParam)
 	shapeHeight, _ := strconv.Atoi(shapeHeightParam)
 	verticalGap, _ := strconv.Atoi(gapParam)
@@ -365,226 +108,610 @@ func (h *ExcelHandler) GenerateExcel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	caseBranches, err := parseCaseBranchesParam(branchesParam)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid 'branches' param: %v", err), http.StatusBadRequest)
+		return
+	}
+
 	if len(shapeTypes) != len(orderFlows) {
 		http.Error(w, "The number of shapes and orders must all match.", http.StatusBadRequest)
 		return
 	}
 
-	file := excelize.NewFile()
-	defer func() {
-		if err := file.Close(); err != nil {
-			fmt.Println(err)
-		}
-	}()
-
-	startColNum, startRowNum, err := excelize.CellNameToCoordinates(startCellParam)
+	labels, err := parseLabelsParam(labelsParam)
 	if err != nil {
-		http.Error(w, "Invalid 'start' parameter. Must be a valid cell reference (e.g., 'G6', 'AA1').", http.StatusBadRequest)
+		http.Error(w, fmt.Sprintf("Invalid 'labels' param: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(labels) > len(shapeTypes) {
+		http.Error(w, "There are more labels than shapes.", http.StatusBadRequest)
 		return
 	}
-	startColIndex := startColNum - 1
-	startRow := startRowNum
 
-	colRows := make(map[int]int)
-	var prevColIndex int
-	var prevRow int
+	actors, err := parseLabelsParam(actorsParam)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid 'actors' param: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(actors) > len(shapeTypes) {
+		http.Error(w, "There are more actors than shapes.", http.StatusBadRequest)
+		return
+	}
 
-	// --- Maps to store locations and branch logic ---
-	shapeLocations := make(map[int]string) // Key: shape index (i), Value: "G6"
-	// shapeOrders map is no longer needed for branching
+	if _, _, err := excelize.CellNameToCoordinates(startCellParam); err != nil {
+		http.Error(w, "Invalid 'start' parameter. Must be a valid cell reference (e.g., 'G6', 'AA1').", http.StatusBadRequest)
+		return
+	}
 
-	// --- FIRST LOOP: Place shapes and record information ---
+	// --- Build the same model the JSON endpoint uses, node id = shape index ---
+	flow := &model.Flowchart{
+		Layout: model.Layout{
+			Mode:   layoutParam,
+			Start:  startCellParam,
+			Width:  shapeWidth,
+			Height: shapeHeight,
+			Pad:    cellPadding,
+			Gap:    verticalGap,
+		},
+	}
+	if layoutParam == "" {
+		// the orders are required, so an order of 0 is a bad column and
+		// does not switch the chart to the auto layout
+		flow.Layout.Mode = model.LayoutManual
+	}
+	if fontSizeParam != "" {
+		fontSize, err := strconv.ParseFloat(fontSizeParam, 64)
+		if err != nil {
+			http.Error(w, "Invalid 'font_size' param.", http.StatusBadRequest)
+			return
+		}
+		flow.Layout.FontSize = fontSize
+	}
+	if autoShrinkParam != "" {
+		autoShrink, err := strconv.ParseBool(autoShrinkParam)
+		if err != nil {
+			http.Error(w, "Invalid 'auto_shrink' param, use true or false.", http.StatusBadRequest)
+			return
+		}
+		flow.Layout.AutoShrink = &autoShrink
+	}
+	if autoSizeParam != "" {
+		autoSize, err := strconv.ParseBool(autoSizeParam)
+		if err != nil {
+			http.Error(w, "Invalid 'auto_size' param, use true or false.", http.StatusBadRequest)
+			return
+		}
+		flow.Layout.AutoSize = autoSize
+	}
+	if resizeCellsParam != "" {
+		resizeCells, err := strconv.ParseBool(resizeCellsParam)
+		if err != nil {
+			http.Error(w, "Invalid 'resize_cells' param, use true or false.", http.StatusBadRequest)
+			return
+		}
+		flow.Layout.ResizeCells = &resizeCells
+	}
+	if groupParam != "" {
+		group, err := strconv.ParseBool(groupParam)
+		if err != nil {
+			http.Error(w, "Invalid 'group' param, use true or false.", http.StatusBadRequest)
+			return
+		}
+		flow.Layout.Group = group
+	}
+	if withTerminatorParam != "" {
+		withTerminator, err := strconv.ParseBool(withTerminatorParam)
+		if err != nil {
+			http.Error(w, "Invalid 'with_terminator' param, use true or false.", http.StatusBadRequest)
+			return
+		}
+		flow.WithTerminator = withTerminator
+	}
+	flow.Layout.Language = langParam
+	flow.Layout.Direction = directionParam
+	// an empty true_label or false_label turns that label off
+	for param, branch := range map[string]string{"true_label": model.BranchTrue, "false_label": model.BranchFalse} {
+		if r.URL.Query().Has(param) {
+			if flow.Layout.BranchLabels == nil {
+				flow.Layout.BranchLabels = map[string]string{}
+			}
+			flow.Layout.BranchLabels[branch] = r.URL.Query().Get(param)
+		}
+	}
 	for i, shapeType := range shapeTypes {
 		orderFlow := orderFlows[i]
 
 		// --- Simplified 'orders' parsing (complex validation removed) ---
-		order, err := strconv.Atoi(orderFlow)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Invalid order number for shape at index %d: %s", i, orderFlow), http.StatusBadRequest)
-			return
+		var order int
+		if orderFlow != "" || ordersRequired {
+			order, err = strconv.Atoi(orderFlow)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid order number for shape at index %d: %s", i, orderFlow), http.StatusBadRequest)
+				return
+			}
 		}
-
-		currentColIndex := startColIndex + (order - 1)
-		var currentRow int
-		if i == 0 {
-			currentRow = startRow
-		} else if currentColIndex == prevColIndex {
-			currentRow = colRows[currentColIndex]
-		} else {
-			currentRow = prevRow
+		node := model.Node{ID: strconv.Itoa(i), Type: shapeType, Column: order}
+		if i < len(labels) {
+			node.Label = labels[i]
 		}
-
-		currentShapeCell := fmt.Sprintf("%c%d", 'A'+currentColIndex, currentRow)
-		currentColName, _ := excelize.ColumnNumberToName(currentColIndex + 1)
-
-		cellWidth := float64(shapeWidth + cellPadding)
-		cellHeight := float64(shapeHeight + cellPadding)
-		file.SetColWidth("Sheet1", currentColName, currentColName, pixelsToCharUnits(cellWidth))
-		file.SetRowHeight("Sheet1", currentRow, pixelsToPoints(cellHeight))
-
-		shape := newFlowchartShape(currentShapeCell, shapeType, uint(shapeWidth), uint(shapeHeight), uint(cellPadding))
-		file.AddShape("Sheet1", shape)
-
-		// --- RECORD data for the second loop ---
-		shapeLocations[i] = currentShapeCell
-		// shapeOrders[i] = order // No longer needed for branching
-
-		// Update state for the next loop
-		colRows[currentColIndex] = currentRow + verticalGap
-		prevColIndex = currentColIndex
-		prevRow = colRows[currentColIndex]
+		if i < len(actors) {
+			node.Actor = actors[i]
+		}
+		flow.Nodes = append(flow.Nodes, node)
 	}
 
-	// --- SECOND LOOP: Draw all arrows ---
 	for i, shapeType := range shapeTypes {
-		originCell := shapeLocations[i]
-		cellWidth := float64(shapeWidth + cellPadding)
-		cellHeight := float64(shapeHeight + cellPadding)
-
-		isDecision := (shapeType == "flowChartDecision")
+		isDecision := (model.ShapeType(shapeType) == "flowChartDecision")
 		hasTrueBranch := false
 		hasFalseBranch := false
 
-		// --- Logic for drawing "true" branch ---
+		// a branch to an unknown index is skipped, same as before
 		if targetIndex, exists := trueBranches[i]; exists {
 			hasTrueBranch = true
-			if targetCell, ok := shapeLocations[targetIndex]; ok {
-				// We have an origin and a target cell, draw the arrow
-				originCol, _, _ := excelize.CellNameToCoordinates(originCell)
-				targetCol, _, _ := excelize.CellNameToCoordinates(targetCell)
-				orientation := "downConn" // default
-				arrowCell := originCell
-				if isDecision { // when the shape is decision
-					if targetCol > originCol {
-						orientation = "downRightConn"
-					} else if targetCol < originCol {
-						orientation = "downLeftConn"
-						arrowCell = targetCell
-					}
-				} else {
-					if targetCol > originCol {
-						orientation = "rightConn"
-					} else if targetCol < originCol {
-						orientation = "leftConn"
-						arrowCell = targetCell // Anchor left to target
-					}
-				}
-
-				line, line2, arrowhead := newArrowShape(arrowCell, originCell, targetCell, orientation, float64(shapeWidth), float64(shapeHeight), cellWidth, cellHeight, cellPadding)
-				file.AddShape("Sheet1", line)
-
-				if line2 != nil {
-					file.AddShape("Sheet1", line2)
-				}
-
-				if arrowhead != nil {
-					file.AddShape("Sheet1", arrowhead)
-				}
+			if targetIndex >= 0 && targetIndex < len(shapeTypes) {
+				flow.Edges = append(flow.Edges, model.Edge{Source: strconv.Itoa(i), Target: strconv.Itoa(targetIndex), Branch: model.BranchTrue})
 			}
 		}
-
-		// --- Logic for drawing "false" branch ---
 		if targetIndex, exists := falseBranches[i]; exists {
 			hasFalseBranch = true
-			if targetCell, ok := shapeLocations[targetIndex]; ok {
-				// We have an origin and a target cell, draw the arrow
-				originCol, _, _ := excelize.CellNameToCoordinates(originCell)
-				targetCol, _, _ := excelize.CellNameToCoordinates(targetCell)
-				orientation := "upperRightConn" // default
-				arrowCell := originCell
-				if targetCol > originCol {
-					orientation = "upperRightConn"
-				} else if targetCol < originCol {
-					orientation = "upperLeftConn"
-					arrowCell = targetCell // Anchor left to target
-				}
-
-				line, line2, arrowhead := newArrowShape(arrowCell, originCell, targetCell, orientation, float64(shapeWidth), float64(shapeHeight), cellWidth, cellHeight, cellPadding)
-				file.AddShape("Sheet1", line)
-				if line2 != nil {
-					file.AddShape("Sheet1", line2)
-				}
-				if arrowhead != nil {
-					file.AddShape("Sheet1", arrowhead)
-				}
+			if targetIndex >= 0 && targetIndex < len(shapeTypes) {
+				flow.Edges = append(flow.Edges, model.Edge{Source: strconv.Itoa(i), Target: strconv.Itoa(targetIndex), Branch: model.BranchFalse})
+			}
+		}
+
+		hasCaseBranch := false
+		for _, branch := range caseBranches {
+			if branch.origin != i {
+				continue
+			}
+			hasCaseBranch = true
+			if branch.target >= 0 && branch.target < len(shapeTypes) {
+				flow.Edges = append(flow.Edges, model.Edge{Source: strconv.Itoa(i), Target: strconv.Itoa(branch.target), Branch: model.BranchNext, Label: branch.label})
 			}
 		}
 
 		// --- Logic for simple sequential connection ---
 		// If this is NOT a decision, and it does NOT have a custom branch,
 		// and it is NOT the last shape in the list...
-		if !isDecision && !hasTrueBranch && !hasFalseBranch && i < len(shapeTypes)-1 {
-			// Connect it to the next shape in the list (i+1)
-			targetCell := shapeLocations[i+1]
-			originCol, _, _ := excelize.CellNameToCoordinates(originCell)
-			targetCol, _, _ := excelize.CellNameToCoordinates(targetCell)
-
-			var orientation string
-			var arrowCell string
-			if originCol == targetCol {
-				orientation = "downConn"
-				arrowCell = originCell
-			} else if targetCol > originCol {
-				orientation = "rightConn"
-				arrowCell = originCell
-			} else {
-				orientation = "leftConn"
-				arrowCell = targetCell // Anchor to the target cell for left connections
-			}
+		if !isDecision && !hasTrueBranch && !hasFalseBranch && !hasCaseBranch && i < len(shapeTypes)-1 {
+			flow.Edges = append(flow.Edges, model.Edge{Source: strconv.Itoa(i), Target: strconv.Itoa(i + 1), Branch: model.BranchNext})
+		}
+	}
 
-			line, line2, arrowhead := newArrowShape(arrowCell, originCell, targetCell, orientation, float64(shapeWidth), float64(shapeHeight), cellWidth, cellHeight, cellPadding)
-			file.AddShape("Sheet1", line)
-			if line2 != nil {
-				file.AddShape("Sheet1", line2)
-			}
-			if arrowhead != nil {
-				file.AddShape("Sheet1", arrowhead)
-			}
+	flow.ApplyDefaults()
+	if err := flow.Validate(); err != nil {
+		writePlainError(w, err)
+		return
+	}
+	flow.AddTerminators()
+
+	h.writeFlowchart(w, r, flow, writePlainError)
+}
+
+// caseBranch is one outgoing edge of a multi-way decision.
+type caseBranch struct {
+	origin, target int
+	label          string
+}
+
+// parseCaseBranchesParam reads the branches query param, any number of
+// origin:target:label entries per shape, CSV style like the labels:
+// branches=4:5:Baru,4:6:Diproses,"4:7:Selesai, arsip"
+func parseCaseBranchesParam(param string) ([]caseBranch, error) {
+	entries, err := parseLabelsParam(param)
+	if err != nil {
+		return nil, err
+	}
+	branches := make([]caseBranch, 0, len(entries))
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid branch format: %s", entry)
 		}
+		origin, err1 := strconv.Atoi(parts[0])
+		target, err2 := strconv.Atoi(parts[1])
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid branch numbers: %s", entry)
+		}
+		branch := caseBranch{origin: origin, target: target}
+		if len(parts) == 3 {
+			branch.label = parts[2]
+		}
+		branches = append(branches, branch)
 	}
+	return branches, nil
+}
 
-	// ... (rest of your handler, writing the file) ...
-	filename := fmt.Sprintf("flowchart_%d.xlsx", rand.Intn(10000))
-	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument/spreadsheetml.sheet")
-	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
-	if err := file.Write(w); err != nil {
-		http.Error(w, "Failed to generate file", http.StatusInternalServerError)
+// parseLabelsParam splits the labels or actors query param like a CSV line, so a label
+// with a comma is written in quotes: labels=Mulai,"Lulus, ya?",Selesai
+func parseLabelsParam(param string) ([]string, error) {
+	if param == "" {
+		return nil, nil
+	}
+	reader := csv.NewReader(strings.NewReader(param))
+	reader.LazyQuotes = true
+	labels, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	for i := range labels {
+		labels[i] = strings.TrimSpace(labels[i])
 	}
+	return labels, nil
 }
 
-// Pixels to points (for SetRowHeight)
-func pixelsToPoints(pixels float64) float64 {
-	if pixels == 0 {
-		return 0
+// GenerateExcelFromJSON is the POST /excel variant of GenerateExcel, the
+// flowchart comes as a model.Flowchart JSON document.
+func (h *ExcelHandler) GenerateExcelFromJSON(w http.ResponseWriter, r *http.Request) {
+	var flow model.Flowchart
+	if err := decodeJSONBody(r, &flow); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	flow.ApplyDefaults()
+	if err := flow.Validate(); err != nil {
+		writeValidationError(w, err)
+		return
 	}
-	return pixels * 3.0 / 4.0 // Adjusted for better accuracy
+	flow.AddTerminators()
+
+	h.writeFlowchart(w, r, &flow, writeValidationError)
 }
 
-// Pixels to character units (for SetColWidth)
-func pixelsToCharUnits(pixels float64) float64 {
-	if pixels <= 0 {
-		return 0
+// GenerateExcelFromNotation renders the key/value table of rancangan.md
+// (IO1, DC2, D9.1=>DC2, P2.1.1, ...) sent to POST /excel/notation.
+func (h *ExcelHandler) GenerateExcelFromNotation(w http.ResponseWriter, r *http.Request) {
+	var req model.NotationRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeValidationError(w, err)
+		return
 	}
-	// This is an approximation, Excel's calculation is complex
-	return (pixels - 5) / 7
+
+	flow, err := service.ParseKeyNotation(&req)
+	if err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	flow.ApplyDefaults()
+	if err := flow.Validate(); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	flow.AddTerminators()
+
+	h.writeFlowchart(w, r, flow, writeValidationError)
 }
 
-// --- CRITICAL FIX: Removed math.Abs ---
-// We need the signed difference to determine direction (left vs. right)
-func getCellDifference(cell1, cell2 string) (width, height int, err error) {
-	col1, row1, err := excelize.CellNameToCoordinates(cell1)
+// GenerateExcelFromMermaid renders a Mermaid flowchart sent to POST
+// /excel/mermaid, either as the plain text of the diagram or as a
+// model.MermaidRequest JSON document with the layout options.
+func (h *ExcelHandler) GenerateExcelFromMermaid(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeValidationError(w, model.ValidationErrors{{Field: "body", Message: err.Error()}})
+		return
+	}
+	var req model.MermaidRequest
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := decodeJSON(bytes.NewReader(trimmed), &req); err != nil {
+			writeValidationError(w, err)
+			return
+		}
+	} else {
+		req.Mermaid = string(body)
+	}
+
+	flow, err := service.ParseMermaid(&req)
 	if err != nil {
-		return 0, 0, fmt.Errorf("invalid first cell name: %w", err)
+		writeValidationError(w, err)
+		return
+	}
+
+	flow.ApplyDefaults()
+	if err := flow.Validate(); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	flow.AddTerminators()
+
+	h.writeFlowchart(w, r, flow, writeValidationError)
+}
+
+// GenerateExcelOnWorkbook draws the flowchart on a sheet of an uploaded
+// workbook (POST /excel/workbook). The multipart form has the xlsx in
+// `workbook` and the same JSON document as POST /excel in `flowchart`.
+func (h *ExcelHandler) GenerateExcelOnWorkbook(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeValidationError(w, model.ValidationErrors{{Field: "body", Message: "must be a multipart form: " + err.Error()}})
+		return
+	}
+	upload, header, err := r.FormFile("workbook")
+	if err != nil {
+		writeValidationError(w, model.ValidationErrors{{Field: "workbook", Message: "an xlsx file is required"}})
+		return
+	}
+	defer upload.Close()
+
+	var flow model.Flowchart
+	if err := decodeJSON(strings.NewReader(r.FormValue("flowchart")), &flow); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	flow.ApplyDefaults()
+	if err := flow.Validate(); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	flow.AddTerminators()
+
+	file, err := excelize.OpenReader(upload)
+	if err != nil {
+		writeValidationError(w, model.ValidationErrors{{Field: "workbook", Message: "not a readable xlsx file: " + err.Error()}})
+		return
+	}
+	sheet := flow.Layout.Sheet
+	if sheet == "" {
+		sheet = file.GetSheetName(0)
+	}
+	if index, err := file.GetSheetIndex(sheet); err != nil || index == -1 {
+		file.Close()
+		writeValidationError(w, model.ValidationErrors{{Field: "layout.sheet", Message: fmt.Sprintf("no sheet %q in the workbook", sheet)}})
+		return
+	}
+
+	if err := h.service.DrawFlowchart(file, sheet, &flow); err != nil {
+		file.Close()
+		var invalid model.ValidationErrors
+		if errors.As(err, &invalid) {
+			writeValidationError(w, err)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to generate file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeWorkbook(w, file, header.Filename)
+}
+
+// ValidateFlowchart checks the same JSON document as POST /excel against the
+// flowchart rules without drawing it (POST /excel/validate), see
+// model.Flowchart.Diagnose. A broken chart is still a 200 with its
+// diagnostics, only a body that is not a flowchart document is a 400.
+func (h *ExcelHandler) ValidateFlowchart(w http.ResponseWriter, r *http.Request) {
+	var flow model.Flowchart
+	if err := decodeJSONBody(r, &flow); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	flow.ApplyDefaults()
+	if flow.Validate() == nil {
+		flow.AddTerminators()
+	}
+	report := flow.Diagnose()
+	if report == nil {
+		report = model.Diagnostics{}
+	}
+
+	errorCount := report.Count(model.SeverityError)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"valid":       errorCount == 0,
+		"errors":      errorCount,
+		"warnings":    report.Count(model.SeverityWarning),
+		"diagnostics": report,
+	})
+}
+
+// writeFlowchart renders the flowchart in the `format` query param (xlsx by
+// default) and sends it back as an attachment.
+func (h *ExcelHandler) writeFlowchart(w http.ResponseWriter, r *http.Request, flow *model.Flowchart, writeInvalid func(http.ResponseWriter, error)) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "xlsx":
+	case "svg":
+		image, err := h.service.RenderSVG(flow)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to generate image: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/svg+xml")
+		setAttachment(w, fmt.Sprintf("flowchart_%d.svg", rand.Intn(10000)))
+		w.Write(image)
+		return
+	case "mermaid":
+		text, err := h.service.RenderMermaid(flow)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to generate text: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		setAttachment(w, fmt.Sprintf("flowchart_%d.mmd", rand.Intn(10000)))
+		w.Write(text)
+		return
+	case "png":
+		scale, err := parseScaleParams(r)
+		if err != nil {
+			writeInvalid(w, err)
+			return
+		}
+		image, err := h.service.RenderPNG(flow, scale)
+		var invalid model.ValidationErrors
+		if errors.As(err, &invalid) {
+			writeInvalid(w, err)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to generate image: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		setAttachment(w, fmt.Sprintf("flowchart_%d.png", rand.Intn(10000)))
+		w.Write(image)
+		return
+	case "pdf":
+		opts, err := parsePDFParams(r)
+		if err != nil {
+			writeInvalid(w, err)
+			return
+		}
+		document, err := h.service.RenderPDF(flow, opts)
+		var invalid model.ValidationErrors
+		if errors.As(err, &invalid) {
+			writeInvalid(w, err)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to generate document: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		setAttachment(w, fmt.Sprintf("flowchart_%d.pdf", rand.Intn(10000)))
+		w.Write(document)
+		return
+	default:
+		writeInvalid(w, model.ValidationErrors{{Field: "format", Message: fmt.Sprintf("unknown format %q, use xlsx, svg, png, pdf or mermaid", format)}})
+		return
 	}
 
-	col2, row2, err := excelize.CellNameToCoordinates(cell2)
+	file, err := h.service.GenerateFlowchart(flow)
+	var invalid model.ValidationErrors
+	if errors.As(err, &invalid) {
+		// e.g. the chart does not fit the sheet from that start cell
+		writeInvalid(w, err)
+		return
+	}
 	if err != nil {
-		return 0, 0, fmt.Errorf("invalid second cell name: %w", err)
+		http.Error(w, fmt.Sprintf("Failed to generate file: %v", err), http.StatusInternalServerError)
+		return
 	}
+	writeWorkbook(w, file, fmt.Sprintf("flowchart_%d.xlsx", rand.Intn(10000)))
+}
 
-	// Return the signed difference
-	width = col2 - col1
-	height = row2 - row1
+// parseScaleParams reads the size of a PNG, either `scale` (1 is the size
+// on the sheet) or `dpi` (96 is the size on the sheet).
+func parseScaleParams(r *http.Request) (float64, error) {
+	query := r.URL.Query()
+	if dpi := query.Get("dpi"); dpi != "" {
+		v, err := strconv.ParseFloat(dpi, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return 0, model.ValidationErrors{{Field: "dpi", Message: fmt.Sprintf("invalid dpi %q", dpi)}}
+		}
+		return service.ImageScale(v), nil
+	}
+	if scale := query.Get("scale"); scale != "" {
+		v, err := strconv.ParseFloat(scale, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return 0, model.ValidationErrors{{Field: "scale", Message: fmt.Sprintf("invalid scale %q", scale)}}
+		}
+		return v, nil
+	}
+	return 1, nil
+}
 
-	return width, height, nil
+// parsePDFParams reads the page settings of a PDF: `paper`, `orientation`
+// (portrait or landscape) and `title`.
+func parsePDFParams(r *http.Request) (service.PDFOptions, error) {
+	query := r.URL.Query()
+	opts := service.PDFOptions{Paper: strings.ToLower(query.Get("paper")), Title: query.Get("title")}
+	switch orientation := query.Get("orientation"); strings.ToLower(orientation) {
+	case "", "portrait":
+	case "landscape":
+		opts.Landscape = true
+	default:
+		return opts, model.ValidationErrors{{Field: "orientation", Message: fmt.Sprintf("unknown orientation %q, use portrait or landscape", orientation)}}
+	}
+	return opts, nil
+}
+
+// writeWorkbook sends the workbook as an xlsx attachment and closes it.
+func writeWorkbook(w http.ResponseWriter, file *excelize.File, filename string) {
+	defer func() {
+		if err := file.Close(); err != nil {
+			fmt.Println(err)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument/spreadsheetml.sheet")
+	setAttachment(w, filename)
+	if err := file.Write(w); err != nil {
+		http.Error(w, "Failed to generate file", http.StatusInternalServerError)
+	}
+}
+
+// setAttachment sets the Content-Disposition of a download. The name is
+// quoted (or encoded when it is not ASCII), an uploaded file can be called
+// anything.
+func setAttachment(w http.ResponseWriter, filename string) {
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+}
+
+// decodeJSONBody decodes the request body into v. Decoding failures are
+// returned as model.ValidationErrors so they point at the bad field too.
+func decodeJSONBody(r *http.Request, v any) error {
+	return decodeJSON(r.Body, v)
+}
+
+func decodeJSON(body io.Reader, v any) error {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+	case errors.Is(err, io.EOF):
+		return model.ValidationErrors{{Field: "body", Message: "request body must not be empty"}}
+	case errors.As(err, &syntaxErr):
+		return model.ValidationErrors{{Field: "body", Message: fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset)}}
+	case errors.As(err, &typeErr):
+		return model.ValidationErrors{{Field: jsonFieldPath(typeErr.Field), Message: fmt.Sprintf("must be of type %s", typeErr.Type)}}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return model.ValidationErrors{{Field: field, Message: "unknown field"}}
+	default:
+		return model.ValidationErrors{{Field: "body", Message: err.Error()}}
+	}
+
+	if decoder.More() {
+		return model.ValidationErrors{{Field: "body", Message: "must contain a single JSON document"}}
+	}
+	return nil
+}
+
+// jsonFieldPath turns the decoder path "nodes.0.column" into "nodes[0].column".
+func jsonFieldPath(path string) string {
+	var b strings.Builder
+	for i, part := range strings.Split(path, ".") {
+		if _, err := strconv.Atoi(part); err == nil {
+			b.WriteString("[" + part + "]")
+			continue
+		}
+		if i > 0 {
+			b.WriteString(".")
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+// writePlainError is the 400 of the GET endpoint, plain text like its other errors.
+func writePlainError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// writeValidationError answers 400 with the list of bad fields as JSON.
+func writeValidationError(w http.ResponseWriter, err error) {
+	var errs model.ValidationErrors
+	if !errors.As(err, &errs) {
+		errs = model.ValidationErrors{{Field: "body", Message: err.Error()}}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":   "invalid flowchart",
+		"details": errs,
+	})
 }
@@ -2,5 +2,5 @@ package model
 
 type Input struct {
 	ID   int    `json:"id"`
-	Name string `json:"name`
+	Name string `json:"name"`
 }
@@ -0,0 +1,287 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Severities of a Diagnostic. An error makes a broken chart, a warning a
+// chart that draws but does not follow the flowchart rules.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Rules a Diagnostic can come from. RuleField is a bad field of the
+// document, as reported by Validate.
+const (
+	RuleField         = "field"
+	RuleStart         = "start"
+	RuleEnd           = "end"
+	RuleDecision      = "decision"
+	RuleDanglingEdge  = "dangling_edge"
+	RuleDuplicateEdge = "duplicate_edge"
+	RuleUnreachable   = "unreachable"
+	RuleNoExit        = "no_exit"
+)
+
+// Diagnostic is one finding about a flowchart: how bad it is, the rule it
+// breaks, the node (and field) it is about and how to fix it.
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	Rule     string `json:"rule"`
+	Node     string `json:"node,omitempty"`
+	Field    string `json:"field,omitempty"`
+	Message  string `json:"message"`
+	Fix      string `json:"fix,omitempty"`
+}
+
+// Diagnostics is the report of Diagnose.
+type Diagnostics []Diagnostic
+
+// Count is the number of diagnostics with the severity.
+func (d Diagnostics) Count(severity string) int {
+	n := 0
+	for _, diag := range d {
+		if diag.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+var nodeField = regexp.MustCompile(`^nodes\[(\d+)\]`)
+
+// Diagnose checks the document with Validate and then the graph with the
+// usual flowchart rules (ISO 5807 style): one start, at least one end,
+// every decision with two or more labelled exits, no dangling or duplicate
+// edges, every node reachable from the start and able to reach an end. Call
+// it after ApplyDefaults, and after AddTerminators when the document is
+// valid so the terminators count. An error about an edge replaces the
+// plain field error of the same edge, it comes with a fix.
+func (f *Flowchart) Diagnose() Diagnostics {
+	rules := f.graphDiagnostics()
+	explained := make(map[string]bool, len(rules))
+	for _, d := range rules {
+		if d.Severity == SeverityError && strings.HasPrefix(d.Field, "edges[") {
+			explained[d.Field] = true
+		}
+	}
+
+	var report Diagnostics
+	var errs ValidationErrors
+	errors.As(f.Validate(), &errs)
+	for _, e := range errs {
+		if explained[e.Field] {
+			continue
+		}
+		d := Diagnostic{Severity: SeverityError, Rule: RuleField, Field: e.Field, Message: e.Message}
+		if m := nodeField.FindStringSubmatch(e.Field); m != nil {
+			if i, _ := strconv.Atoi(m[1]); i < len(f.Nodes) {
+				d.Node = f.Nodes[i].ID
+			}
+		}
+		report = append(report, d)
+	}
+	return append(report, rules...)
+}
+
+// graphDiagnostics runs the rules on the edges between known nodes, the
+// other edges are reported as dangling.
+func (f *Flowchart) graphDiagnostics() Diagnostics {
+	var report Diagnostics
+	add := func(severity, rule, node, field, message, fix string) {
+		report = append(report, Diagnostic{Severity: severity, Rule: rule, Node: node, Field: field, Message: message, Fix: fix})
+	}
+	if len(f.Nodes) == 0 {
+		return nil
+	}
+
+	index := f.NodeIndex()
+	out := make([][]int, len(f.Nodes)) // edge indexes leaving every node
+	in := make([][]int, len(f.Nodes))
+	seen := make(map[[2]string]int)
+	for i, edge := range f.Edges {
+		field := fmt.Sprintf("edges[%d]", i)
+		from, ok1 := index[edge.Source]
+		to, ok2 := index[edge.Target]
+		if edge.Source != "" && !ok1 {
+			add(SeverityError, RuleDanglingEdge, "", field+".source", fmt.Sprintf("the edge comes from unknown node %q", edge.Source),
+				fmt.Sprintf("use the id of an existing node or add node %q", edge.Source))
+		}
+		if edge.Target != "" && !ok2 {
+			add(SeverityError, RuleDanglingEdge, edge.Source, field+".target", fmt.Sprintf("the edge points at unknown node %q", edge.Target),
+				fmt.Sprintf("use the id of an existing node or add node %q", edge.Target))
+		}
+		if !ok1 || !ok2 {
+			continue
+		}
+		key := [2]string{edge.Source, edge.Target}
+		if first, ok := seen[key]; ok {
+			add(SeverityError, RuleDuplicateEdge, edge.Source, field, fmt.Sprintf("duplicate edge %q -> %q, already edges[%d]", edge.Source, edge.Target, first),
+				"remove one of them, the exits of a decision go to different steps")
+			continue
+		}
+		seen[key] = i
+		out[from] = append(out[from], i)
+		in[to] = append(in[to], i)
+	}
+
+	// --- start and end ---
+	var starts, ends []int
+	for i := range f.Nodes {
+		if len(in[i]) == 0 {
+			starts = append(starts, i)
+		}
+		if len(out[i]) == 0 {
+			ends = append(ends, i)
+		}
+	}
+	const addStart = `add a flowChartTerminator start before the first step or set "with_terminator": true`
+	const addEnd = `add a flowChartTerminator end after the last step or set "with_terminator": true`
+	start := -1
+	if len(starts) == 0 {
+		add(SeverityError, RuleStart, "", "", "the chart has no start, every node has an arrow coming in", addStart)
+	} else {
+		// a terminator is the start the others are compared to
+		start = starts[0]
+		for _, i := range starts {
+			if f.Nodes[i].Type == TerminatorType {
+				start = i
+				break
+			}
+		}
+		if f.Nodes[start].Type != TerminatorType {
+			add(SeverityWarning, RuleStart, f.Nodes[start].ID, fmt.Sprintf("nodes[%d]", start), fmt.Sprintf("the chart starts at %q and not at a terminator", f.Nodes[start].ID), addStart)
+		}
+		for _, i := range starts {
+			if i != start {
+				add(SeverityError, RuleStart, f.Nodes[i].ID, fmt.Sprintf("nodes[%d]", i), fmt.Sprintf("%q is a second start, no arrow comes into it", f.Nodes[i].ID),
+					fmt.Sprintf("add an arrow into %q from the step before it, or remove it", f.Nodes[i].ID))
+			}
+		}
+	}
+	if len(ends) == 0 {
+		add(SeverityError, RuleEnd, "", "", "the chart has no end, every node has an arrow going out", addEnd)
+	}
+	for _, i := range ends {
+		if f.Nodes[i].Type != TerminatorType {
+			add(SeverityWarning, RuleEnd, f.Nodes[i].ID, fmt.Sprintf("nodes[%d]", i), fmt.Sprintf("the flow stops at %q without an end terminator", f.Nodes[i].ID), addEnd)
+		}
+	}
+
+	// --- decisions ---
+	for i, node := range f.Nodes {
+		field := fmt.Sprintf("nodes[%d]", i)
+		if node.Type != "flowChartDecision" {
+			if len(out[i]) > 1 && node.Type != NodeFork {
+				add(SeverityWarning, RuleDecision, node.ID, field, fmt.Sprintf("%q has %d exits but is not a decision", node.ID, len(out[i])),
+					`make it a decision ("D") so the exits are outcomes, or a "fork" when they run at the same time`)
+			}
+			continue
+		}
+		if len(out[i]) < 2 {
+			add(SeverityError, RuleDecision, node.ID, field, fmt.Sprintf("decision %q has %d exit(s), it needs two or more", node.ID, len(out[i])),
+				fmt.Sprintf(`add a "true" and a "false" edge leaving %q`, node.ID))
+		}
+		labels := make(map[string]int)
+		for _, e := range out[i] {
+			edge := f.Edges[e]
+			if edge.Label == "" {
+				// Validate already wants the labels of a switch
+				severity := SeverityWarning
+				if len(out[i]) > 2 {
+					severity = SeverityError
+				}
+				add(severity, RuleDecision, node.ID, fmt.Sprintf("edges[%d].label", e), fmt.Sprintf("the exit %q -> %q of decision %q has no label", edge.Source, edge.Target, node.ID),
+					`give the edge a "label" or make it the "true" or "false" branch`)
+				continue
+			}
+			if first, ok := labels[edge.Label]; ok {
+				add(SeverityWarning, RuleDecision, node.ID, fmt.Sprintf("edges[%d].label", e), fmt.Sprintf("decision %q has two exits labelled %q, edges[%d] and edges[%d]", node.ID, edge.Label, first, e),
+					"label every exit with a different outcome")
+				continue
+			}
+			labels[edge.Label] = e
+		}
+	}
+
+	// --- reachability, forward from the starts and back from the ends ---
+	walk := func(from []int, next func(v int) []int) []bool {
+		reached := make([]bool, len(f.Nodes))
+		stack := append([]int(nil), from...)
+		for _, v := range from {
+			reached[v] = true
+		}
+		for len(stack) > 0 {
+			v := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, w := range next(v) {
+				if !reached[w] {
+					reached[w] = true
+					stack = append(stack, w)
+				}
+			}
+		}
+		return reached
+	}
+	successors := func(v int) []int {
+		var next []int
+		for _, e := range out[v] {
+			next = append(next, index[f.Edges[e].Target])
+		}
+		return next
+	}
+	predecessors := func(v int) []int {
+		var next []int
+		for _, e := range in[v] {
+			next = append(next, index[f.Edges[e].Source])
+		}
+		return next
+	}
+
+	entries := starts
+	if len(entries) == 0 {
+		entries = []int{0}
+	}
+	reachable := walk(entries, successors)
+	for i, node := range f.Nodes {
+		if !reachable[i] {
+			add(SeverityError, RuleUnreachable, node.ID, fmt.Sprintf("nodes[%d]", i), fmt.Sprintf("%q cannot be reached from the start", node.ID),
+				fmt.Sprintf("add an arrow into %q from a step before it, or remove it", node.ID))
+		}
+	}
+
+	// a node that cannot reach an end runs into a loop with no way out, the
+	// loop is the one everything it reaches can come back from. With no end
+	// at all there is nothing to reach, that is reported above.
+	if len(ends) > 0 {
+		finishes := walk(ends, predecessors)
+		for i, node := range f.Nodes {
+			if finishes[i] {
+				continue
+			}
+			ahead, behind := walk([]int{i}, successors), walk([]int{i}, predecessors)
+			var loop []string
+			for v := range f.Nodes {
+				if ahead[v] && !behind[v] {
+					loop = nil
+					break
+				}
+				if ahead[v] {
+					loop = append(loop, strconv.Quote(f.Nodes[v].ID))
+				}
+			}
+			// the first node of the loop reports it
+			if loop == nil || loop[0] != strconv.Quote(node.ID) {
+				continue
+			}
+			add(SeverityError, RuleNoExit, node.ID, fmt.Sprintf("nodes[%d]", i), fmt.Sprintf("the loop through %s never reaches an end", strings.Join(loop, ", ")),
+				"add an exit to the loop, e.g. a decision with a branch to the next step")
+		}
+	}
+	return report
+}
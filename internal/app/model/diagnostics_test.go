@@ -0,0 +1,95 @@
+package model
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDiagnose(t *testing.T) {
+	term := func(id string) Node { return Node{ID: id, Type: TerminatorType, Label: id} }
+	step := func(id string) Node { return Node{ID: id, Type: "P", Label: id} }
+	decision := func(id string) Node { return Node{ID: id, Type: "D", Label: id + "?"} }
+	edge := func(source, target string) Edge { return Edge{Source: source, Target: target} }
+	branch := func(source, target, kind string) Edge { return Edge{Source: source, Target: target, Branch: kind} }
+
+	tests := []struct {
+		name  string
+		nodes []Node
+		edges []Edge
+		want  []string // severity rule node
+	}{
+		{
+			name:  "clean",
+			nodes: []Node{term("start"), step("a"), decision("d"), term("end")},
+			edges: []Edge{edge("start", "a"), edge("a", "d"), branch("d", "end", BranchTrue), branch("d", "a", BranchFalse)},
+		},
+		{
+			name:  "no terminators",
+			nodes: []Node{step("a"), step("b")},
+			edges: []Edge{edge("a", "b")},
+			want:  []string{"warning start a", "warning end b"},
+		},
+		{
+			name:  "decision with one exit",
+			nodes: []Node{term("start"), decision("d"), term("end")},
+			edges: []Edge{edge("start", "d"), branch("d", "end", BranchTrue)},
+			want:  []string{"error decision d"},
+		},
+		{
+			name:  "two exits without decision",
+			nodes: []Node{term("start"), step("a"), term("x"), term("y")},
+			edges: []Edge{edge("start", "a"), edge("a", "x"), edge("a", "y")},
+			want:  []string{"warning decision a"},
+		},
+		{
+			name:  "second start",
+			nodes: []Node{term("start"), step("a"), step("b"), term("end")},
+			edges: []Edge{edge("start", "a"), edge("a", "end"), edge("b", "end")},
+			want:  []string{"error start b"},
+		},
+		{
+			name:  "loop without exit",
+			nodes: []Node{term("start"), step("a"), term("end"), step("x"), step("y")},
+			edges: []Edge{edge("start", "a"), edge("a", "end"), edge("x", "y"), edge("y", "x")},
+			want:  []string{"error unreachable x", "error unreachable y", "error no_exit x"},
+		},
+		{
+			name:  "duplicate edge",
+			nodes: []Node{term("start"), step("a"), term("end")},
+			edges: []Edge{edge("start", "a"), edge("a", "end"), edge("a", "end")},
+			want:  []string{"error duplicate_edge a"},
+		},
+		{
+			name:  "both branches to the same step",
+			nodes: []Node{term("start"), decision("d"), term("end")},
+			edges: []Edge{edge("start", "d"), branch("d", "end", BranchTrue), branch("d", "end", BranchFalse)},
+			want:  []string{"error duplicate_edge d", "error decision d"},
+		},
+		{
+			// the dangling edge replaces the field error of the same edge
+			name:  "dangling edge",
+			nodes: []Node{term("start"), step("a"), term("end")},
+			edges: []Edge{edge("start", "a"), edge("a", "end"), edge("a", "z")},
+			want:  []string{"error dangling_edge a"},
+		},
+		{
+			name:  "bad field",
+			nodes: []Node{term("start"), {ID: "a", Type: "blob"}, term("end")},
+			edges: []Edge{edge("start", "a"), edge("a", "end")},
+			want:  []string{"error field a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Flowchart{Nodes: tt.nodes, Edges: tt.edges, Layout: Layout{Mode: LayoutAuto}}
+			f.ApplyDefaults()
+			var got []string
+			for _, d := range f.Diagnose() {
+				got = append(got, d.Severity+" "+d.Rule+" "+d.Node)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Diagnose() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"errors"
+	"strings"
+)
+
+// FieldError points at one bad field of a request body, e.g. "nodes[2].type".
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationErrors collects every FieldError found in a request body.
+type ValidationErrors []FieldError
+
+func (errs *ValidationErrors) Add(field, message string) {
+	*errs = append(*errs, FieldError{Field: field, Message: message})
+}
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ErrorFields lists the field of every FieldError in err, in order. It is
+// nil for a nil err, ok is false when err is not ValidationErrors.
+func ErrorFields(err error) (fields []string, ok bool) {
+	if err == nil {
+		return nil, true
+	}
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		return nil, false
+	}
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	return fields, true
+}
@@ -0,0 +1,374 @@
+package model
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Branch kinds of an edge. An empty branch is treated as BranchNext.
+const (
+	BranchNext  = "next"
+	BranchTrue  = "true"
+	BranchFalse = "false"
+)
+
+// Languages of the texts the chart adds by itself, like the labels of the
+// decision branches.
+const (
+	LanguageID      = "id"
+	LanguageEN      = "en"
+	DefaultLanguage = LanguageID
+)
+
+// BranchLabels are the default edge labels of every language by branch kind.
+// A "next" edge has no label unless it is given one.
+var BranchLabels = map[string]map[string]string{
+	LanguageID: {BranchTrue: "Ya", BranchFalse: "Tidak"},
+	LanguageEN: {BranchTrue: "Yes", BranchFalse: "No"},
+}
+
+// Layout modes. Manual places every node in its `column`, auto computes the
+// columns and rows from the edges (a node with a column set keeps it).
+const (
+	LayoutManual = "manual"
+	LayoutAuto   = "auto"
+)
+
+// Directions the flow runs in: top to bottom, bottom to top, left to right
+// or right to left. The branches spread across it.
+const (
+	DirectionTB      = "TB"
+	DirectionBT      = "BT"
+	DirectionLR      = "LR"
+	DirectionRL      = "RL"
+	DefaultDirection = DirectionTB
+)
+
+// Layout defaults, same values used by the README examples.
+const (
+	DefaultStart  = "B2"
+	DefaultWidth  = 120
+	DefaultHeight = 65
+	DefaultPad    = 30
+	DefaultGap    = 1
+
+	DefaultFontSize = 14
+)
+
+// Limits of the layout sizes in pixels. A shape below the minimum has no
+// room left for its text once the insets are taken off (a decision keeps
+// only half its width for it), a larger one no longer fits a sheet.
+const (
+	MinShapeWidth  = 40
+	MinShapeHeight = 20
+	MaxShapeWidth  = 1000
+	MaxShapeHeight = 500
+	MaxPad         = 200
+	MaxGap         = 10
+)
+
+// Flowchart is the JSON document accepted by POST /excel. WithTerminator
+// adds the start and end terminators, see AddTerminators.
+type Flowchart struct {
+	Nodes          []Node            `json:"nodes"`
+	Edges          []Edge            `json:"edges"`
+	Layout         Layout            `json:"layout"`
+	Metadata       map[string]string `json:"metadata,omitempty"`
+	WithTerminator bool              `json:"with_terminator,omitempty"`
+}
+
+// Node is one shape of the flowchart. Column is 1-based, same as `orders`,
+// and can be left out (0) with the auto layout. Nodes with the same Group
+// (e.g. the steps of a subprocess) go in a group shape of their own. Width
+// and Height override the size of the layout for this node, 0 keeps it.
+// Actor is the role doing the step, every actor gets a swimlane. Note is an
+// annotation drawn beside the shape, for a comment that is not a step.
+type Node struct {
+	ID       string            `json:"id"`
+	Type     string            `json:"type"`
+	Label    string            `json:"label,omitempty"`
+	Column   int               `json:"column"`
+	Width    int               `json:"width,omitempty"`
+	Height   int               `json:"height,omitempty"`
+	Actor    string            `json:"actor,omitempty"`
+	Note     string            `json:"note,omitempty"`
+	Group    string            `json:"group,omitempty"`
+	Style    *Style            `json:"style,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Edge connects two nodes by their id. The label is written next to the
+// line where it leaves the source, a decision branch without one gets the
+// default label of its branch.
+type Edge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Branch string `json:"branch,omitempty"`
+	Label  string `json:"label,omitempty"`
+}
+
+// Layout holds the sizing options that used to come from the query string.
+type Layout struct {
+	Mode   string `json:"mode,omitempty"`
+	Start  string `json:"start"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Pad    int    `json:"pad"`
+	Gap    int    `json:"gap"`
+
+	// Direction the steps follow each other in, TB (default), BT, LR or
+	// RL. With LR and RL the columns of the layout become rows.
+	Direction string `json:"direction,omitempty"`
+	// Lanes orders the swimlanes of the node actors, the ones left out
+	// follow in the order of the nodes. A lane can be listed with no node.
+	Lanes []string `json:"lanes,omitempty"`
+
+	// FontSize of the node labels in points. With AutoShrink (default true)
+	// the size goes down until a long label fits its shape.
+	FontSize   float64 `json:"font_size,omitempty"`
+	AutoShrink *bool   `json:"auto_shrink,omitempty"`
+	// AutoSize sizes every node without its own width or height to its
+	// label at FontSize, from half the layout size up to twice its width.
+	AutoSize bool `json:"auto_size,omitempty"`
+
+	// ResizeCells (default true) makes the columns and rows under the chart
+	// as big as the shape slots. With false the sheet keeps its sizes, the
+	// shapes land on the same pixels anyway.
+	ResizeCells *bool `json:"resize_cells,omitempty"`
+	// Language of the default texts, "id" (default) or "en". BranchLabels
+	// overrides the labels of the language by branch kind, e.g.
+	// {"true": "True", "false": "False"}, an empty label turns it off.
+	Language     string            `json:"language,omitempty"`
+	BranchLabels map[string]string `json:"branch_labels,omitempty"`
+	// Group puts the whole chart in one group shape, so it moves, scales
+	// and copies as one object in Excel.
+	Group bool `json:"group,omitempty"`
+	// Sheet to draw on when the chart goes into an uploaded workbook, the
+	// first sheet by default.
+	Sheet string `json:"sheet,omitempty"`
+}
+
+// Style overrides the default colors of a node, hex RGB like "FFFFFF".
+type Style struct {
+	Fill      string `json:"fill,omitempty"`
+	Line      string `json:"line,omitempty"`
+	FontColor string `json:"font_color,omitempty"`
+}
+
+// ApplyDefaults fills the zero layout options with the defaults. Without a
+// mode the layout is manual when every node has a column, auto otherwise.
+// A node type given as a key of ShapeAliases becomes its preset.
+func (f *Flowchart) ApplyDefaults() {
+	if f.Layout.Mode == "" {
+		f.Layout.Mode = LayoutManual
+		for _, node := range f.Nodes {
+			if node.Column == 0 {
+				f.Layout.Mode = LayoutAuto
+				break
+			}
+		}
+	}
+	f.Layout.Direction = strings.ToUpper(f.Layout.Direction)
+	if f.Layout.Direction == "" {
+		f.Layout.Direction = DefaultDirection
+	}
+	if f.Layout.Start == "" {
+		f.Layout.Start = DefaultStart
+	}
+	if f.Layout.Width == 0 {
+		f.Layout.Width = DefaultWidth
+	}
+	if f.Layout.Height == 0 {
+		f.Layout.Height = DefaultHeight
+	}
+	if f.Layout.Pad == 0 {
+		f.Layout.Pad = DefaultPad
+	}
+	if f.Layout.Gap == 0 {
+		f.Layout.Gap = DefaultGap
+	}
+	if f.Layout.FontSize == 0 {
+		f.Layout.FontSize = DefaultFontSize
+	}
+	if f.Layout.AutoShrink == nil {
+		shrink := true
+		f.Layout.AutoShrink = &shrink
+	}
+	if f.Layout.ResizeCells == nil {
+		resize := true
+		f.Layout.ResizeCells = &resize
+	}
+	if f.Layout.Language == "" {
+		f.Layout.Language = DefaultLanguage
+	}
+	for i := range f.Nodes {
+		f.Nodes[i].Type = ShapeType(f.Nodes[i].Type)
+	}
+	for i := range f.Edges {
+		if f.Edges[i].Branch == "" {
+			f.Edges[i].Branch = BranchNext
+		}
+		if f.Edges[i].Label == "" {
+			f.Edges[i].Label = f.branchLabel(f.Edges[i].Branch)
+		}
+	}
+}
+
+// branchLabel is the default label of an edge of the branch kind.
+func (f *Flowchart) branchLabel(branch string) string {
+	if label, ok := f.Layout.BranchLabels[branch]; ok {
+		return label
+	}
+	return BranchLabels[f.Layout.Language][branch]
+}
+
+// NodeIndex maps every node id to its position in Nodes.
+func (f *Flowchart) NodeIndex() map[string]int {
+	index := make(map[string]int, len(f.Nodes))
+	for i, node := range f.Nodes {
+		index[node.ID] = i
+	}
+	return index
+}
+
+// Validate checks the document field by field and returns ValidationErrors
+// pointing at every bad field, or nil.
+func (f *Flowchart) Validate() error {
+	var errs ValidationErrors
+
+	if f.Layout.Mode != LayoutManual && f.Layout.Mode != LayoutAuto {
+		errs.Add("layout.mode", fmt.Sprintf("must be %q or %q", LayoutAuto, LayoutManual))
+	}
+	switch f.Layout.Direction {
+	case DirectionTB, DirectionBT, DirectionLR, DirectionRL:
+	default:
+		errs.Add("layout.direction", fmt.Sprintf("must be one of %q, %q, %q or %q", DirectionTB, DirectionBT, DirectionLR, DirectionRL))
+	}
+	if _, _, err := excelize.CellNameToCoordinates(f.Layout.Start); err != nil {
+		errs.Add("layout.start", "must be a valid cell reference (e.g. 'G6', 'AA1')")
+	}
+	validateRange(&errs, "layout.width", f.Layout.Width, MinShapeWidth, MaxShapeWidth)
+	validateRange(&errs, "layout.height", f.Layout.Height, MinShapeHeight, MaxShapeHeight)
+	validateRange(&errs, "layout.pad", f.Layout.Pad, 0, MaxPad)
+	validateRange(&errs, "layout.gap", f.Layout.Gap, 1, MaxGap)
+	if f.Layout.FontSize < 1 || f.Layout.FontSize > 400 {
+		errs.Add("layout.font_size", "must be between 1 and 400")
+	}
+	if _, ok := BranchLabels[f.Layout.Language]; !ok {
+		errs.Add("layout.language", fmt.Sprintf("must be %q or %q", LanguageID, LanguageEN))
+	}
+	for _, branch := range slices.Sorted(maps.Keys(f.Layout.BranchLabels)) {
+		switch branch {
+		case BranchNext, BranchTrue, BranchFalse:
+		default:
+			errs.Add("layout.branch_labels."+branch, fmt.Sprintf("must be one of %q, %q or %q", BranchNext, BranchTrue, BranchFalse))
+		}
+	}
+
+	if len(f.Nodes) == 0 {
+		errs.Add("nodes", "at least one node is required")
+	}
+	seen := make(map[string]int)
+	for i, node := range f.Nodes {
+		field := fmt.Sprintf("nodes[%d]", i)
+		if node.ID == "" {
+			errs.Add(field+".id", "is required")
+		} else if first, ok := seen[node.ID]; ok {
+			errs.Add(field+".id", fmt.Sprintf("duplicate id %q, already used by nodes[%d]", node.ID, first))
+		} else {
+			seen[node.ID] = i
+		}
+		if node.Type == "" {
+			errs.Add(field+".type", "is required")
+		} else if !Shapes[node.Type] {
+			errs.Add(field+".type", fmt.Sprintf("unknown shape type %q, use a key (%s) or a preset like %q", node.Type, shapeKeys(), "flowChartDecision"))
+		}
+		// 0 keeps the size of the layout
+		if node.Width != 0 {
+			validateRange(&errs, field+".width", node.Width, MinShapeWidth, MaxShapeWidth)
+		}
+		if node.Height != 0 {
+			validateRange(&errs, field+".height", node.Height, MinShapeHeight, MaxShapeHeight)
+		}
+		if node.Column < 0 || node.Column == 0 && f.Layout.Mode == LayoutManual {
+			errs.Add(field+".column", "must be 1 or greater")
+		}
+		if node.Note != "" && IsBar(node.Type) {
+			errs.Add(field+".note", "a fork or join bar cannot have a note, put it on a step of a branch")
+		}
+		if node.Style != nil {
+			validateColor(&errs, field+".style.fill", node.Style.Fill)
+			validateColor(&errs, field+".style.line", node.Style.Line)
+			validateColor(&errs, field+".style.font_color", node.Style.FontColor)
+		}
+	}
+
+	// the branches of a decision with more than two (a switch) are told
+	// apart by their labels only
+	branches := make(map[string]int)
+	for _, edge := range f.Edges {
+		branches[edge.Source]++
+	}
+	for i, edge := range f.Edges {
+		field := fmt.Sprintf("edges[%d]", i)
+		if edge.Source == "" {
+			errs.Add(field+".source", "is required")
+		} else if _, ok := seen[edge.Source]; !ok {
+			errs.Add(field+".source", fmt.Sprintf("unknown node id %q", edge.Source))
+		}
+		if edge.Target == "" {
+			errs.Add(field+".target", "is required")
+		} else if _, ok := seen[edge.Target]; !ok {
+			errs.Add(field+".target", fmt.Sprintf("unknown node id %q", edge.Target))
+		}
+		switch edge.Branch {
+		case "", BranchNext, BranchTrue, BranchFalse:
+		default:
+			errs.Add(field+".branch", fmt.Sprintf("must be one of %q, %q or %q", BranchNext, BranchTrue, BranchFalse))
+		}
+		if n, ok := seen[edge.Source]; ok && f.Nodes[n].Type == "flowChartDecision" && branches[edge.Source] > 2 && edge.Label == "" {
+			errs.Add(field+".label", fmt.Sprintf("is required, decision %q has %d branches", edge.Source, branches[edge.Source]))
+		}
+	}
+
+	f.validateLanes(&errs)
+
+	// the forks and joins are only checked on a graph with good ids
+	if len(errs) == 0 {
+		f.validateParallel(&errs)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateRange(errs *ValidationErrors, field string, value, min, max int) {
+	if value < min || value > max {
+		errs.Add(field, fmt.Sprintf("must be between %d and %d", min, max))
+	}
+}
+
+func validateColor(errs *ValidationErrors, field, color string) {
+	if color == "" {
+		return
+	}
+	if len(color) == 7 && color[0] == '#' {
+		color = color[1:]
+	}
+	if len(color) != 6 {
+		errs.Add(field, "must be a hex RGB color like \"FFFFFF\"")
+		return
+	}
+	for _, c := range color {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			errs.Add(field, "must be a hex RGB color like \"FFFFFF\"")
+			return
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package model
+
+import (
+	"slices"
+	"testing"
+)
+
+// validFlow is a small chart that passes Validate, the cases break one thing.
+func validFlow() *Flowchart {
+	f := &Flowchart{
+		Nodes: []Node{
+			{ID: "a", Type: "IO", Label: "Isi formulir", Column: 1},
+			{ID: "b", Type: "D", Label: "Lengkap?", Column: 1},
+			{ID: "c", Type: "P", Label: "Simpan", Column: 2},
+		},
+		Edges: []Edge{
+			{Source: "a", Target: "b"},
+			{Source: "b", Target: "c", Branch: BranchTrue},
+			{Source: "b", Target: "a", Branch: BranchFalse},
+		},
+	}
+	f.ApplyDefaults()
+	return f
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(f *Flowchart)
+		fields []string
+	}{
+		{"valid", func(f *Flowchart) {}, nil},
+		{"smallest shape", func(f *Flowchart) { f.Layout.Width, f.Layout.Height = MinShapeWidth, MinShapeHeight }, nil},
+		{"largest layout", func(f *Flowchart) {
+			f.Layout.Width, f.Layout.Height, f.Layout.Pad, f.Layout.Gap = MaxShapeWidth, MaxShapeHeight, MaxPad, MaxGap
+		}, nil},
+		{"narrow layout", func(f *Flowchart) { f.Layout.Width = 10 }, []string{"layout.width"}},
+		{"wide layout", func(f *Flowchart) { f.Layout.Width = 5000 }, []string{"layout.width"}},
+		{"flat layout", func(f *Flowchart) { f.Layout.Height = 5 }, []string{"layout.height"}},
+		{"high layout", func(f *Flowchart) { f.Layout.Height = 5000 }, []string{"layout.height"}},
+		{"negative pad", func(f *Flowchart) { f.Layout.Pad = -1 }, []string{"layout.pad"}},
+		{"huge pad", func(f *Flowchart) { f.Layout.Pad = 1000 }, []string{"layout.pad"}},
+		{"no gap", func(f *Flowchart) { f.Layout.Gap = 0 }, []string{"layout.gap"}},
+		{"huge gap", func(f *Flowchart) { f.Layout.Gap = 100 }, []string{"layout.gap"}},
+		{"narrow node", func(f *Flowchart) { f.Nodes[1].Width = 30 }, []string{"nodes[1].width"}},
+		{"high node", func(f *Flowchart) { f.Nodes[1].Height = 800 }, []string{"nodes[1].height"}},
+		{"bad start", func(f *Flowchart) { f.Layout.Start = "1A" }, []string{"layout.start"}},
+		{"bad direction", func(f *Flowchart) { f.Layout.Direction = "XY" }, []string{"layout.direction"}},
+		{"bad font size", func(f *Flowchart) { f.Layout.FontSize = 0.5 }, []string{"layout.font_size"}},
+		{"bad language", func(f *Flowchart) { f.Layout.Language = "fr" }, []string{"layout.language"}},
+		{"duplicate id", func(f *Flowchart) { f.Nodes[2].ID = "a" }, []string{"nodes[2].id", "edges[1].target"}},
+		{"unknown type", func(f *Flowchart) { f.Nodes[0].Type = "flowChartDecison" }, []string{"nodes[0].type"}},
+		{"no column", func(f *Flowchart) { f.Nodes[2].Column = 0 }, []string{"nodes[2].column"}},
+		{"no column in auto", func(f *Flowchart) { f.Layout.Mode, f.Nodes[2].Column = LayoutAuto, 0 }, nil},
+		{"note", func(f *Flowchart) { f.Nodes[2].Note = "Arsip di map biru" }, nil},
+		{"note on a bar", func(f *Flowchart) { f.Nodes[2].Type, f.Nodes[2].Note = NodeJoin, "Tunggu semua" }, []string{"nodes[2].note"}},
+		{"bad color", func(f *Flowchart) { f.Nodes[0].Style = &Style{Fill: "red"} }, []string{"nodes[0].style.fill"}},
+		{"unknown target", func(f *Flowchart) { f.Edges[0].Target = "z" }, []string{"edges[0].target"}},
+		{"bad branch", func(f *Flowchart) { f.Edges[0].Branch = "maybe" }, []string{"edges[0].branch"}},
+		{"switch without label", func(f *Flowchart) {
+			f.Nodes = append(f.Nodes, Node{ID: "d", Type: "flowChartProcess", Column: 3})
+			f.Edges = append(f.Edges, Edge{Source: "b", Target: "d"})
+		}, []string{"edges[3].label"}},
+		{"no nodes", func(f *Flowchart) { f.Nodes, f.Edges = nil, nil }, []string{"nodes"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := validFlow()
+			tt.change(f)
+			err := f.Validate()
+			fields, ok := ErrorFields(err)
+			if !ok {
+				t.Fatalf("Validate() = %v, want ValidationErrors", err)
+			}
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("Validate() fields = %q, want %q", fields, tt.fields)
+			}
+		})
+	}
+}
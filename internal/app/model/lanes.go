@@ -0,0 +1,45 @@
+package model
+
+import "fmt"
+
+// LaneNames are the swimlanes of the chart in order: layout.lanes first,
+// then the other actors in the order of their first node. A chart without
+// actors and lanes has none.
+func (f *Flowchart) LaneNames() []string {
+	names := append([]string(nil), f.Layout.Lanes...)
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		seen[name] = true
+	}
+	for _, node := range f.Nodes {
+		if node.Actor != "" && !seen[node.Actor] {
+			seen[node.Actor] = true
+			names = append(names, node.Actor)
+		}
+	}
+	return names
+}
+
+// validateLanes checks the lane list and that with swimlanes every shape is
+// in one. A bar has no lane of its own, it spans the lanes of its branches.
+func (f *Flowchart) validateLanes(errs *ValidationErrors) {
+	seen := make(map[string]int)
+	for i, name := range f.Layout.Lanes {
+		field := fmt.Sprintf("layout.lanes[%d]", i)
+		if name == "" {
+			errs.Add(field, "must not be empty")
+		} else if first, ok := seen[name]; ok {
+			errs.Add(field, fmt.Sprintf("duplicate lane %q, already layout.lanes[%d]", name, first))
+		} else {
+			seen[name] = i
+		}
+	}
+	if len(f.LaneNames()) == 0 {
+		return
+	}
+	for i, node := range f.Nodes {
+		if node.Actor == "" && !IsBar(node.Type) {
+			errs.Add(fmt.Sprintf("nodes[%d].actor", i), "is required, the chart has swimlanes")
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package model
+
+// MermaidRequest is the body of POST /excel/mermaid, a Mermaid flowchart
+// with the same options as the key notation. A layout direction overrides
+// the one of the flowchart header.
+type MermaidRequest struct {
+	Mermaid        string `json:"mermaid"`
+	WithTerminator bool   `json:"with_terminator"`
+	Layout         Layout `json:"layout"`
+}
@@ -0,0 +1,58 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// NotationRequest is the body of POST /excel/notation, the key/value table
+// described in rancangan.md.
+type NotationRequest struct {
+	Flowchart      NotationTable `json:"flowchart"`
+	WithTerminator bool          `json:"with_terminator"`
+	Layout         Layout        `json:"layout"`
+}
+
+// NotationEntry is one row of the table, e.g. {"D9.1=>DC2", "Tidak"}.
+type NotationEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// NotationTable keeps the rows in the order they were sent. It can be sent as
+// a JSON object {"IO1": "..."} or as an array [{"key": "IO1", "value": "..."}].
+type NotationTable []NotationEntry
+
+func (t *NotationTable) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var entries []NotationEntry
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return err
+		}
+		*t = entries
+		return nil
+	}
+
+	// read the object token by token so the row order is kept
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := decoder.Token(); err != nil || tok != json.Delim('{') {
+		return fmt.Errorf("flowchart must be an object or an array of {key, value}")
+	}
+	var entries []NotationEntry
+	for decoder.More() {
+		tok, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+		var value string
+		if err := decoder.Decode(&value); err != nil {
+			return fmt.Errorf("flowchart value of %q must be a string", key)
+		}
+		entries = append(entries, NotationEntry{Key: key, Value: value})
+	}
+	*t = entries
+	return nil
+}
@@ -0,0 +1,117 @@
+package model
+
+import "fmt"
+
+// Node types of parallel branches, drawn as thick synchronization bars. The
+// edges leaving a fork are steps done at the same time, a join waits for all
+// of them.
+const (
+	NodeFork = "fork"
+	NodeJoin = "join"
+)
+
+// IsBar tells if the node type is drawn as a synchronization bar.
+func IsBar(nodeType string) bool {
+	return nodeType == NodeFork || nodeType == NodeJoin
+}
+
+// validateParallel checks that every fork is matched by a join: every path
+// leaving the fork meets the same join first (a fork and join pair inside a
+// branch is stepped over), and no join closes more than one fork.
+func (f *Flowchart) validateParallel(errs *ValidationErrors) {
+	index := f.NodeIndex()
+	out := make([][]int, len(f.Nodes))
+	incoming := make([]int, len(f.Nodes))
+	for _, edge := range f.Edges {
+		from, ok1 := index[edge.Source]
+		to, ok2 := index[edge.Target]
+		if ok1 && ok2 {
+			out[from] = append(out[from], to)
+			incoming[to]++
+		}
+	}
+
+	matched := make(map[int]int) // fork -> its join, -1 when there is none
+	var match func(fork int) int
+	match = func(fork int) int {
+		if join, ok := matched[fork]; ok {
+			return join
+		}
+		matched[fork] = -1 // a branch looping back to its own fork stops there
+		join, broken := -1, false
+		seen := make(map[int]bool)
+		var walk func(v int)
+		walk = func(v int) {
+			if broken || seen[v] {
+				return
+			}
+			seen[v] = true
+			switch f.Nodes[v].Type {
+			case NodeJoin:
+				if join == -1 {
+					join = v
+				} else if join != v {
+					broken = true
+					errs.Add(fmt.Sprintf("nodes[%d]", fork), fmt.Sprintf("the branches of fork %q meet at different joins %q and %q", f.Nodes[fork].ID, f.Nodes[join].ID, f.Nodes[v].ID))
+				}
+				return
+			case NodeFork:
+				if inner := match(v); inner >= 0 {
+					for _, w := range out[inner] {
+						walk(w)
+					}
+				}
+				return
+			}
+			if len(out[v]) == 0 {
+				broken = true
+				errs.Add(fmt.Sprintf("nodes[%d]", fork), fmt.Sprintf("a branch of fork %q ends at %q without a join", f.Nodes[fork].ID, f.Nodes[v].ID))
+				return
+			}
+			for _, w := range out[v] {
+				walk(w)
+			}
+		}
+		for _, w := range out[fork] {
+			walk(w)
+		}
+		if broken {
+			return -1
+		}
+		if join == -1 {
+			errs.Add(fmt.Sprintf("nodes[%d]", fork), fmt.Sprintf("fork %q has no matching join", f.Nodes[fork].ID))
+		}
+		matched[fork] = join
+		return join
+	}
+
+	closes := make(map[int]int) // join -> the fork it closes
+	for i, node := range f.Nodes {
+		field := fmt.Sprintf("nodes[%d]", i)
+		switch node.Type {
+		case NodeFork:
+			if len(out[i]) < 2 {
+				errs.Add(field, fmt.Sprintf("fork %q needs at least two outgoing edges", node.ID))
+				continue
+			}
+			join := match(i)
+			if join < 0 {
+				continue
+			}
+			if other, ok := closes[join]; ok {
+				errs.Add(field, fmt.Sprintf("join %q already closes fork %q", f.Nodes[join].ID, f.Nodes[other].ID))
+				continue
+			}
+			closes[join] = i
+		case NodeJoin:
+			if incoming[i] < 2 {
+				errs.Add(field, fmt.Sprintf("join %q needs at least two incoming edges", node.ID))
+			}
+		}
+	}
+	for i, node := range f.Nodes {
+		if _, ok := closes[i]; node.Type == NodeJoin && !ok && incoming[i] >= 2 {
+			errs.Add(fmt.Sprintf("nodes[%d]", i), fmt.Sprintf("join %q is not matched by a fork", node.ID))
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ShapeAliases maps the short keys of the rancangan.md table to the excelize
+// preset they are drawn as. A node type can be either.
+var ShapeAliases = map[string]string{
+	"ON": "flowChartConnector",         // On-Page Reference
+	"OF": "flowChartOffpageConnector",  // Off-Page Reference
+	"P":  "flowChartProcess",           // Process
+	"D":  "flowChartDecision",          // Decision
+	"IO": "flowChartInputOutput",       // Input/Output
+	"MO": "flowChartManualOperation",   // Manual Operation
+	"DC": "flowChartDocument",          // Document
+	"PP": "flowChartPredefinedProcess", // Predefined Process
+	"DS": "flowChartDisplay",           // Display
+	"PR": "flowChartPreparation",       // Preparation
+}
+
+// Shapes are the excelize presets a node can have, the ones every output
+// draws the same. Anything else is rejected, excelize would write it anyway
+// and Excel would refuse to open the drawing.
+var Shapes = map[string]bool{
+	"rect": true, "roundRect": true, "ellipse": true, "diamond": true,
+	"parallelogram": true, "hexagon": true, "triangle": true, "can": true,
+
+	"flowChartProcess": true, "flowChartAlternateProcess": true,
+	"flowChartDecision": true, "flowChartInputOutput": true,
+	"flowChartPredefinedProcess": true, "flowChartInternalStorage": true,
+	"flowChartDocument": true, "flowChartTerminator": true,
+	"flowChartPreparation": true, "flowChartManualInput": true,
+	"flowChartManualOperation": true, "flowChartConnector": true,
+	"flowChartOffpageConnector": true, "flowChartPunchedCard": true,
+	"flowChartSummingJunction": true, "flowChartOr": true,
+	"flowChartCollate": true, "flowChartSort": true,
+	"flowChartExtract": true, "flowChartMerge": true,
+	"flowChartDelay": true, "flowChartDisplay": true,
+	"flowChartMagneticDisk": true,
+
+	NodeFork: true, NodeJoin: true,
+}
+
+// ShapeType is the preset of a node type, the type itself when it is not
+// an alias.
+func ShapeType(nodeType string) string {
+	if preset, ok := ShapeAliases[nodeType]; ok {
+		return preset
+	}
+	return nodeType
+}
+
+// shapeKeys lists the aliases for an error message.
+func shapeKeys() string {
+	return strings.Join(slices.Sorted(maps.Keys(ShapeAliases)), ", ")
+}
@@ -0,0 +1,60 @@
+package model
+
+import "strconv"
+
+// TerminatorType is the shape of the start and end nodes.
+const TerminatorType = "flowChartTerminator"
+
+// TerminatorLabels are the labels of the start and end terminators added by
+// with_terminator, by language.
+var TerminatorLabels = map[string][2]string{
+	LanguageID: {"Mulai", "Selesai"},
+	LanguageEN: {"Start", "End"},
+}
+
+// AddTerminators adds the start and end terminators when WithTerminator is
+// set: a start before the first node and an end after every node that
+// points nowhere, each wired to its node and in its column and lane. Nodes
+// that already are terminators get none. Call it after Validate, the start goes
+// in front of the nodes.
+func (f *Flowchart) AddTerminators() {
+	if !f.WithTerminator || len(f.Nodes) == 0 {
+		return
+	}
+	labels := TerminatorLabels[f.Layout.Language]
+	taken := make(map[string]bool, len(f.Nodes))
+	hasOutgoing := make(map[string]bool)
+	for _, node := range f.Nodes {
+		taken[node.ID] = true
+	}
+	for _, edge := range f.Edges {
+		hasOutgoing[edge.Source] = true
+	}
+	newID := func(base string) string {
+		id := base
+		for n := 2; taken[id]; n++ {
+			id = base + "_" + strconv.Itoa(n)
+		}
+		taken[id] = true
+		return id
+	}
+
+	first := f.Nodes[0]
+	nodes := make([]Node, 0, len(f.Nodes)+2)
+	if first.Type != TerminatorType {
+		start := Node{ID: newID("start"), Type: TerminatorType, Label: labels[0], Column: first.Column, Actor: first.Actor}
+		nodes = append(nodes, start)
+		f.Edges = append(f.Edges, Edge{Source: start.ID, Target: first.ID, Branch: BranchNext})
+	}
+	for _, node := range f.Nodes {
+		nodes = append(nodes, node)
+		if hasOutgoing[node.ID] || node.Type == TerminatorType {
+			continue
+		}
+		// right after its node, so the manual layout puts it below
+		end := Node{ID: newID("end"), Type: TerminatorType, Label: labels[1], Column: node.Column, Actor: node.Actor}
+		nodes = append(nodes, end)
+		f.Edges = append(f.Edges, Edge{Source: node.ID, Target: end.ID, Branch: BranchNext})
+	}
+	f.Nodes = nodes
+}
@@ -18,6 +18,11 @@ func NewRouter(excelHandler *handler.ExcelHandler) http.Handler {
 
 	// Routes
 	r.Get("/excel", excelHandler.GenerateExcel)
+	r.Post("/excel", excelHandler.GenerateExcelFromJSON)
+	r.Post("/excel/notation", excelHandler.GenerateExcelFromNotation)
+	r.Post("/excel/mermaid", excelHandler.GenerateExcelFromMermaid)
+	r.Post("/excel/workbook", excelHandler.GenerateExcelOnWorkbook)
+	r.Post("/excel/validate", excelHandler.ValidateFlowchart)
 
 	return r
 }
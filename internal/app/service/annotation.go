@@ -0,0 +1,76 @@
+package service
+
+import (
+	"go_excelize/internal/app/model"
+	"math"
+)
+
+// Look of the annotations, shared by every output. Sizes in pixels.
+const (
+	noteLineColor = "7F7F7F"
+	noteFontColor = "595959"
+	noteLineWidth = 0.75 // points
+	noteTick      = 8.0  // how far the ends of the bracket reach over the text
+	noteMinGap    = 16.0 // between a shape and its note when pad is smaller
+	noteDash      = 4.0  // a dash of the link, and the space after it
+)
+
+// sceneNote is the annotation of a node (ISO 5807): the text in an open
+// bracket beside the shape and a dashed line from the shape to the bracket.
+// A note sits before its shape across the flow, left of it when the flow
+// runs down and above it when the flow runs across. The connectors keep to
+// the other side, see avoidNotes.
+type sceneNote struct {
+	node  string // id of the node it is about
+	box   rect
+	label labelFit
+	side  side     // of the box the bracket runs along, facing the shape
+	link  [2]point // from the outline of the shape to the bracket
+}
+
+// bracket is the open bracket along the side of the box facing the shape.
+func (n *sceneNote) bracket() []point {
+	b := n.box
+	if n.side == sideBottom {
+		return []point{{b.x, b.bottom() - noteTick}, {b.x, b.bottom()}, {b.right(), b.bottom()}, {b.right(), b.bottom() - noteTick}}
+	}
+	return []point{{b.right() - noteTick, b.y}, {b.right(), b.y}, {b.right(), b.bottom()}, {b.right() - noteTick, b.bottom()}}
+}
+
+// noteSize is the width and height of the note of a node: the size of the
+// layout, or with layout.auto_size the size of its text.
+func noteSize(note string, layout model.Layout) (float64, float64) {
+	w, h := float64(layout.Width), float64(layout.Height)
+	if layout.AutoSize {
+		w, h = autoSize(note, "rect", layout.FontSize, w/2, h/2, 2*w)
+	}
+	return w, h
+}
+
+// noteKey is the key of the note of a node among the boxes given to
+// routeEdges, the connectors go around a note like around a node.
+func noteKey(id string) string {
+	return "\x00note" + id
+}
+
+// noteGap is the space between a shape and its note, a channel as wide as
+// the one between two columns so the connectors can still pass.
+func noteGap(pad float64) float64 {
+	return math.Max(pad, noteMinGap)
+}
+
+// dashes cuts the line from a to b in dashes of noteDash, for the outputs
+// that cannot stroke a dashed line.
+func dashes(a, b point) [][]point {
+	length := math.Hypot(b.x-a.x, b.y-a.y)
+	if length == 0 {
+		return nil
+	}
+	dx, dy := (b.x-a.x)/length, (b.y-a.y)/length
+	var out [][]point
+	for d := 0.0; d < length; d += 2 * noteDash {
+		end := math.Min(d+noteDash, length)
+		out = append(out, []point{{a.x + dx*d, a.y + dy*d}, {a.x + dx*end, a.y + dy*end}})
+	}
+	return out
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"go_excelize/internal/app/model"
+	"testing"
+)
+
+func TestSceneNotes(t *testing.T) {
+	for _, dir := range []string{model.DirectionTB, model.DirectionBT, model.DirectionLR, model.DirectionRL} {
+		flow := &model.Flowchart{
+			Nodes: []model.Node{
+				{ID: "a", Type: "IO", Label: "Isi formulir", Note: "Formulir A1, rangkap dua"},
+				{ID: "d", Type: "D", Label: "Lengkap?", Note: "Cek KTP dan KK"},
+				{ID: "b", Type: "P", Label: "Simpan"},
+				{ID: "c", Type: "P", Label: "Tolak", Note: "Kirim email"},
+			},
+			Edges: []model.Edge{
+				{Source: "a", Target: "d"},
+				{Source: "d", Target: "b", Branch: model.BranchTrue},
+				{Source: "d", Target: "c", Branch: model.BranchFalse},
+				{Source: "c", Target: "a"},
+			},
+			Layout: model.Layout{Direction: dir},
+		}
+		flow.ApplyDefaults()
+		sc := layoutScene(flow, point{})
+		if len(sc.notes) != 3 {
+			t.Fatalf("%s: %d notes, want 3", dir, len(sc.notes))
+		}
+
+		nodes := make(map[string]rect)
+		for _, n := range sc.nodes {
+			nodes[n.node.ID] = n.box
+		}
+		linked := make(map[string]side)
+		for _, note := range sc.notes {
+			for id, box := range nodes {
+				if note.box.overlaps(box) {
+					t.Errorf("%s: note of %s overlaps %s", dir, note.node, id)
+				}
+			}
+			// before the shape across the flow: left of it, or above it
+			box := nodes[note.node]
+			if across(dir) && note.box.bottom() > box.y || !across(dir) && note.box.right() > box.x {
+				t.Errorf("%s: note of %s at %+v, not before its shape %+v", dir, note.node, note.box, box)
+			}
+			linked[note.node] = note.side.opposite()
+		}
+		for _, r := range sc.routes {
+			if s, ok := linked[r.edge.Source]; ok && r.exit == s {
+				t.Errorf("%s: %s->%s leaves on the side of the note", dir, r.edge.Source, r.edge.Target)
+			}
+			if s, ok := linked[r.edge.Target]; ok && r.entry == s {
+				t.Errorf("%s: %s->%s enters on the side of the note", dir, r.edge.Source, r.edge.Target)
+			}
+		}
+
+		list := sc.drawables()
+		for _, d := range list[len(list)-len(sc.notes):] {
+			if d.layer != layerAnnotations || d.note == nil {
+				t.Errorf("%s: drawable %+v on top, want the notes", dir, d)
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// --- Routed paths as DrawingML connectors ---
+//
+// A connector shape (cxnSp) holds on to the connection sites of its start and
+// end shapes, so Excel reroutes it when a shape is moved. The routed path is
+// stored as the bent connector preset with as many segments, which always
+// starts with a horizontal segment at the top left of its frame and ends at
+// the bottom right. Flips and a quarter turn of the frame give the other
+// directions, the adjust values put the inner segments where the router did.
+
+// connectorGeometry is the frame and preset a path is drawn with.
+type connectorGeometry struct {
+	preset       string
+	adjusts      []int   // adj1, adj2... in 1/100000 of the frame
+	custom       []point // the path in the frame, for a custom geometry
+	box          rect    // frame before the rotation, sheet pixels
+	rot90        bool    // turned a quarter clockwise around its center
+	flipH, flipV bool
+}
+
+// bentPresets by number of segments, see presetShapeDefinitions.
+var bentPresets = map[int]string{
+	1: "straightConnector1",
+	2: "bentConnector2",
+	3: "bentConnector3",
+	4: "bentConnector4",
+	5: "bentConnector5",
+}
+
+// connectorFor fits the orthogonal path to a connector. A path with more
+// bends than the presets have is kept as a custom geometry, still connected
+// to both shapes.
+func connectorFor(points []point) connectorGeometry {
+	segments := len(points) - 1
+	preset, ok := bentPresets[segments]
+	if !ok {
+		return customConnector(points)
+	}
+	start, end := points[0], points[segments]
+	g := connectorGeometry{preset: preset}
+	if segments == 1 {
+		// a straight line is drawn corner to corner, whatever its direction
+		g.box = rect{math.Min(start.x, end.x), math.Min(start.y, end.y), math.Abs(end.x - start.x), math.Abs(end.y - start.y)}
+		g.flipH, g.flipV = end.x < start.x, end.y < start.y
+		return g
+	}
+
+	// a path leaving vertically is the preset turned a quarter, the frame
+	// has the width and height swapped around the same center
+	g.rot90 = points[1].x == start.x
+	center := point{(start.x + end.x) / 2, (start.y + end.y) / 2}
+	w, h := math.Abs(end.x-start.x), math.Abs(end.y-start.y)
+	if g.rot90 {
+		w, h = h, w
+	}
+	// a side of zero would make the adjust values infinite
+	w, h = math.Max(w, 1), math.Max(h, 1)
+	g.box = rect{center.x - w/2, center.y - h/2, w, h}
+
+	// local is a point in the frame, before the flips, from its center
+	local := func(p point) point {
+		d := point{p.x - center.x, p.y - center.y}
+		if g.rot90 {
+			return point{d.y, -d.x}
+		}
+		return d
+	}
+	s, e := local(start), local(end)
+	g.flipH, g.flipV = s.x > e.x, s.y > e.y
+	frame := func(p point) point {
+		l := local(p)
+		if g.flipH {
+			l.x = -l.x
+		}
+		if g.flipV {
+			l.y = -l.y
+		}
+		return point{l.x + w/2, l.y + h/2}
+	}
+
+	// adj1 is the x of the first bend, adj2 the y of the second, and so on
+	for i := 1; i < segments-1; i++ {
+		p := frame(points[i])
+		if i%2 == 1 {
+			g.adjusts = append(g.adjusts, int(math.Round(p.x/w*100000)))
+		} else {
+			g.adjusts = append(g.adjusts, int(math.Round(p.y/h*100000)))
+		}
+	}
+	return g
+}
+
+// anchorBox is where the connector is anchored on the sheet. Like Excel, a
+// shape turned a quarter is anchored by its turned bounds, which are the
+// bounds of the path ends.
+func (g connectorGeometry) anchorBox() rect {
+	if !g.rot90 {
+		return g.box
+	}
+	c := g.box.center()
+	return rect{c.x - g.box.h/2, c.y - g.box.w/2, g.box.h, g.box.w}
+}
+
+// customConnector is the path in its bounding box.
+func customConnector(points []point) connectorGeometry {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range points {
+		minX, minY = math.Min(minX, p.x), math.Min(minY, p.y)
+		maxX, maxY = math.Max(maxX, p.x), math.Max(maxY, p.y)
+	}
+	g := connectorGeometry{box: rect{minX, minY, math.Max(maxX-minX, 1), math.Max(maxY-minY, 1)}}
+	for _, p := range points {
+		g.custom = append(g.custom, point{p.x - minX, p.y - minY})
+	}
+	return g
+}
+
+// geometryXML is the xfrm and geometry of the connector, EMU from the top
+// left of the sheet.
+func (g connectorGeometry) geometryXML() string {
+	emu := func(v float64) int { return int(math.Round(v * emuPerPixel)) }
+	var b strings.Builder
+	b.WriteString("<a:xfrm")
+	if g.rot90 {
+		b.WriteString(` rot="5400000"`)
+	}
+	if g.flipH {
+		b.WriteString(` flipH="1"`)
+	}
+	if g.flipV {
+		b.WriteString(` flipV="1"`)
+	}
+	fmt.Fprintf(&b, `><a:off x="%d" y="%d"></a:off><a:ext cx="%d" cy="%d"></a:ext></a:xfrm>`,
+		emu(g.box.x), emu(g.box.y), emu(g.box.w), emu(g.box.h))
+
+	if g.custom != nil {
+		fmt.Fprintf(&b, `<a:custGeom><a:avLst></a:avLst><a:gdLst></a:gdLst><a:ahLst></a:ahLst><a:cxnLst></a:cxnLst><a:rect l="0" t="0" r="r" b="b"></a:rect><a:pathLst><a:path w="%d" h="%d">`,
+			emu(g.box.w), emu(g.box.h))
+		for i, p := range g.custom {
+			op := "lnTo"
+			if i == 0 {
+				op = "moveTo"
+			}
+			fmt.Fprintf(&b, `<a:%[1]s><a:pt x="%d" y="%d"></a:pt></a:%[1]s>`, op, emu(p.x), emu(p.y))
+		}
+		b.WriteString(`</a:path></a:pathLst></a:custGeom>`)
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, `<a:prstGeom prst="%s"><a:avLst>`, g.preset)
+	for i, adj := range g.adjusts {
+		fmt.Fprintf(&b, `<a:gd name="adj%d" fmla="val %d"></a:gd>`, i+1, adj)
+	}
+	b.WriteString(`</a:avLst></a:prstGeom>`)
+	return b.String()
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"go_excelize/internal/app/model"
+	"math"
+)
+
+// --- Flow directions ---
+//
+// The layout and the choice of connector sides think of a chart that runs
+// down: the steps follow each other along +y and the branches spread along
+// x. The flow frame is the sheet turned so the direction of the chart points
+// down, the grid of the layout is turned the same way onto the sheet.
+
+// across tells if the steps follow each other across the sheet.
+func across(direction string) bool {
+	return direction == model.DirectionLR || direction == model.DirectionRL
+}
+
+// sheetSlots turns the grid of the layout onto the sheet: with LR and RL its
+// rows become columns, BT and RL count them from the other end.
+func sheetSlots(direction string, positions map[string]gridPos) map[string]gridPos {
+	last := 0
+	for _, pos := range positions {
+		last = max(last, pos.row)
+	}
+	slots := make(map[string]gridPos, len(positions))
+	for id, pos := range positions {
+		switch direction {
+		case model.DirectionBT:
+			slots[id] = gridPos{col: pos.col, row: last - pos.row}
+		case model.DirectionLR:
+			slots[id] = gridPos{col: pos.row, row: pos.col}
+		case model.DirectionRL:
+			slots[id] = gridPos{col: last - pos.row, row: pos.col}
+		default:
+			slots[id] = pos
+		}
+	}
+	return slots
+}
+
+// toFlow maps a point of the sheet to the flow frame.
+func toFlow(direction string, p point) point {
+	switch direction {
+	case model.DirectionBT:
+		return point{p.x, -p.y}
+	case model.DirectionLR:
+		return point{p.y, p.x}
+	case model.DirectionRL:
+		return point{p.y, -p.x}
+	}
+	return p
+}
+
+// fromFlow maps a point of the flow frame back to the sheet.
+func fromFlow(direction string, p point) point {
+	switch direction {
+	case model.DirectionBT:
+		return point{p.x, -p.y}
+	case model.DirectionLR:
+		return point{p.y, p.x}
+	case model.DirectionRL:
+		return point{-p.y, p.x}
+	}
+	return p
+}
+
+// rectToFlow is the box in the flow frame.
+func rectToFlow(direction string, r rect) rect {
+	a, b := toFlow(direction, point{r.x, r.y}), toFlow(direction, point{r.right(), r.bottom()})
+	return rect{math.Min(a.x, b.x), math.Min(a.y, b.y), math.Abs(b.x - a.x), math.Abs(b.y - a.y)}
+}
+
+// rectFromFlow is the box of the flow frame on the sheet.
+func rectFromFlow(direction string, r rect) rect {
+	a, b := fromFlow(direction, point{r.x, r.y}), fromFlow(direction, point{r.right(), r.bottom()})
+	return rect{math.Min(a.x, b.x), math.Min(a.y, b.y), math.Abs(b.x - a.x), math.Abs(b.y - a.y)}
+}
+
+// sideFromFlow is the side of the sheet a side of the flow frame faces.
+func sideFromFlow(direction string, s side) side {
+	dx, dy := s.step()
+	v := fromFlow(direction, point{float64(dx), float64(dy)})
+	for c := sideTop; c <= sideLeft; c++ {
+		if cx, cy := c.step(); float64(cx) == v.x && float64(cy) == v.y {
+			return c
+		}
+	}
+	return s
+}
@@ -0,0 +1,282 @@
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// patchDrawings runs patch over the XML of every drawing part of the file.
+// excelize keeps the drawings parsed until the file is written, so they are
+// serialized here (same way excelize does it), patched and stored back as
+// raw parts. Call it after the last AddShape. excelize only loads the
+// drawings that something was added to, so an uploaded workbook keeps the
+// drawings of the other sheets untouched.
+func patchDrawings(file *excelize.File, patch func(path string, content []byte) []byte) error {
+	var err error
+	file.Drawings.Range(func(path, drawing any) bool {
+		if drawing == nil {
+			return true
+		}
+		var content []byte
+		if content, err = xml.Marshal(drawing); err != nil {
+			return false
+		}
+		content = patch(path.(string), content)
+		file.Pkg.Store(path, append([]byte(xml.Header), content...))
+		file.Drawings.Delete(path)
+		return true
+	})
+	return err
+}
+
+// centerShapeText centers the text of a shape, excelize always anchors it to
+// the top left.
+func centerShapeText(content []byte) []byte {
+	content = bytes.ReplaceAll(content, []byte(`<a:bodyPr anchor="t"`), []byte(`<a:bodyPr anchor="ctr"`))
+	return bytes.ReplaceAll(content, []byte(`<a:p><a:r>`), []byte(`<a:p><a:pPr algn="ctr"></a:pPr><a:r>`))
+}
+
+// textOnly takes the fill, the outline and the insets off a text box, its
+// text may run past the box instead of being cut.
+func textOnly(content []byte) []byte {
+	content = bytes.Replace(content, []byte(`</a:prstGeom>`), []byte(`</a:prstGeom><a:noFill></a:noFill>`), 1)
+	content = lineEndPattern.ReplaceAll(content, []byte(`$1<a:noFill></a:noFill>$2`))
+	content = bytes.Replace(content, []byte(`<a:bodyPr `), []byte(`<a:bodyPr lIns="0" tIns="0" rIns="0" bIns="0" `), 1)
+	return bytes.ReplaceAll(content, []byte(`Overflow="clip"`), []byte(`Overflow="overflow"`))
+}
+
+// anchorItem is where a shape added with AddShape really goes, in sheet
+// pixels. excelize computes the "to" anchor with its own column and row
+// sizes (and oneCell forgets the offset), so the anchors are rewritten from
+// these boxes instead.
+type anchorItem struct {
+	box        rect
+	arrow      bool // a line ends with an arrowhead
+	dashed     bool
+	centerText bool
+	textOnly   bool // a text box without fill, outline and insets
+	connector  *connectorItem
+}
+
+// connectorItem makes the line added for an edge a connector shape bound to
+// the shapes of two other items, added before or after it.
+type connectorItem struct {
+	geometry         connectorGeometry
+	from, to         int // indexes of the items of the source and target, -1 for a loose end
+	fromSite, toSite int // connection sites, see shapePorts
+}
+
+// anchorGroup puts items in a group shape, so they move and copy as one.
+// Groups nest, e.g. a subprocess inside the whole chart.
+type anchorGroup struct {
+	name   string
+	items  []int
+	groups []*anchorGroup
+}
+
+var (
+	anchorPattern   = regexp.MustCompile(`(?s)<xdr:twoCellAnchor[ >].*?</xdr:twoCellAnchor>`)
+	fromToPattern   = regexp.MustCompile(`(?s)<xdr:from>.*</xdr:to>`)
+	lineEndPattern  = regexp.MustCompile(`(<a:ln[^>]*>)(</a:ln>)`)
+	lineElemPattern = regexp.MustCompile(`(?s)<a:ln[ >].*?</a:ln>`)
+	stylePattern    = regexp.MustCompile(`(?s)<xdr:style>.*?</xdr:style>`)
+	shapePattern    = regexp.MustCompile(`(?s)<xdr:sp[ >].*</xdr:sp>`)
+	shapeIDPattern  = regexp.MustCompile(`<xdr:cNvPr id="(\d+)"`)
+	childPattern    = regexp.MustCompile(`(?s)</xdr:to>(.*)<xdr:clientData`)
+	emptyXfrm       = []byte(`<a:off x="0" y="0"></a:off><a:ext cx="0" cy="0"></a:ext>`)
+)
+
+// placeAnchors returns a patch that moves the anchors added last to the
+// items, in the order the shapes were added, and puts them in the groups.
+// Shapes the sheet already had come first in the drawing and are left alone.
+func placeAnchors(items []anchorItem, groups []*anchorGroup, geom *sheetGeometry) func(string, []byte) []byte {
+	return func(_ string, content []byte) []byte {
+		locs := anchorPattern.FindAllIndex(content, -1)
+		first := len(locs) - len(items)
+		if len(items) == 0 || first < 0 {
+			return content
+		}
+		// the ids first, a connector can be drawn before its shapes
+		ids := make([]string, len(items))
+		for i := range items {
+			if m := shapeIDPattern.FindSubmatch(content[locs[first+i][0]:locs[first+i][1]]); m != nil {
+				ids[i] = string(m[1])
+			}
+		}
+		placed := make([][]byte, len(items))
+		for i, item := range items {
+			anchor := append([]byte(nil), content[locs[first+i][0]:locs[first+i][1]]...)
+			from := anchorMarker("from", geom, point{item.box.x, item.box.y})
+			to := anchorMarker("to", geom, point{item.box.right(), item.box.bottom()})
+			anchor = fromToPattern.ReplaceAll(anchor, []byte(from+to))
+			if item.centerText {
+				anchor = centerShapeText(anchor)
+			}
+			if item.textOnly {
+				anchor = textOnly(anchor)
+			}
+			if item.dashed {
+				anchor = lineEndPattern.ReplaceAll(anchor, []byte(`$1<a:prstDash val="dash"></a:prstDash>$2`))
+			}
+			if item.arrow {
+				anchor = lineEndPattern.ReplaceAll(anchor, []byte(`$1<a:tailEnd type="triangle" w="med" len="med"></a:tailEnd>$2`))
+			}
+			if item.connector != nil {
+				anchor = shapePattern.ReplaceAll(anchor, connectorShape(anchor, ids[i], item.connector, ids))
+			} else {
+				// a shape in a group is placed by its xfrm, not by an anchor
+				anchor = bytes.Replace(anchor, emptyXfrm, []byte(xfrmPlacement(item.box)), 1)
+			}
+			placed[i] = anchor
+		}
+
+		out := append([]byte(nil), content[:locs[first][0]]...)
+		if len(groups) == 0 {
+			for _, anchor := range placed {
+				out = append(out, anchor...)
+			}
+		} else {
+			nextID := 1
+			for _, m := range shapeIDPattern.FindAllSubmatch(content, -1) {
+				if id, err := strconv.Atoi(string(m[1])); err == nil && id >= nextID {
+					nextID = id + 1
+				}
+			}
+			grouped := make([]bool, len(items))
+			var markGrouped func(g *anchorGroup)
+			markGrouped = func(g *anchorGroup) {
+				for _, i := range g.items {
+					grouped[i] = true
+				}
+				for _, sub := range g.groups {
+					markGrouped(sub)
+				}
+			}
+			for _, g := range groups {
+				markGrouped(g)
+			}
+			// every group goes where its first item was
+			for i, anchor := range placed {
+				if !grouped[i] {
+					out = append(out, anchor...)
+				}
+				for _, g := range groups {
+					if g.first() == i {
+						shape, box := groupShape(g, items, placed, &nextID)
+						out = append(out, groupAnchor(geom, box, shape)...)
+					}
+				}
+			}
+		}
+		return append(out, content[locs[len(locs)-1][1]:]...)
+	}
+}
+
+// first is the lowest item of the group and the groups in it.
+func (g *anchorGroup) first() int {
+	first := -1
+	for _, i := range g.items {
+		if first < 0 || i < first {
+			first = i
+		}
+	}
+	for _, sub := range g.groups {
+		if i := sub.first(); i >= 0 && (first < 0 || i < first) {
+			first = i
+		}
+	}
+	return first
+}
+
+// groupShape is the grpSp of the group and the box around its shapes. The
+// children keep the sheet EMU of their own xfrm, the group maps its child
+// space one to one on its frame.
+func groupShape(g *anchorGroup, items []anchorItem, placed [][]byte, nextID *int) ([]byte, rect) {
+	type child struct {
+		order int
+		xml   []byte
+		box   rect
+	}
+	var children []child
+	for _, i := range g.items {
+		if m := childPattern.FindSubmatch(placed[i]); m != nil {
+			children = append(children, child{i, m[1], items[i].box})
+		}
+	}
+	for _, sub := range g.groups {
+		shape, box := groupShape(sub, items, placed, nextID)
+		children = append(children, child{sub.first(), shape, box})
+	}
+	sort.SliceStable(children, func(a, b int) bool { return children[a].order < children[b].order })
+
+	var box rect
+	for i, c := range children {
+		if i == 0 {
+			box = c.box
+		} else {
+			box = box.union(c.box)
+		}
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<xdr:grpSp><xdr:nvGrpSpPr><xdr:cNvPr id="%d" name="%s"></xdr:cNvPr><xdr:cNvGrpSpPr></xdr:cNvGrpSpPr></xdr:nvGrpSpPr>`, *nextID, xmlText(g.name))
+	*nextID++
+	off, ext := emuPoint(point{box.x, box.y}), emuPoint(point{box.w, box.h})
+	fmt.Fprintf(&b, `<xdr:grpSpPr><a:xfrm><a:off x="%[1]d" y="%[2]d"></a:off><a:ext cx="%[3]d" cy="%[4]d"></a:ext><a:chOff x="%[1]d" y="%[2]d"></a:chOff><a:chExt cx="%[3]d" cy="%[4]d"></a:chExt></a:xfrm></xdr:grpSpPr>`,
+		off[0], off[1], ext[0], ext[1])
+	for _, c := range children {
+		b.Write(c.xml)
+	}
+	b.WriteString(`</xdr:grpSp>`)
+	return b.Bytes(), box
+}
+
+// groupAnchor wraps a shape in a twoCellAnchor over the box.
+func groupAnchor(geom *sheetGeometry, box rect, shape []byte) []byte {
+	from := anchorMarker("from", geom, point{box.x, box.y})
+	to := anchorMarker("to", geom, point{box.right(), box.bottom()})
+	return []byte(`<xdr:twoCellAnchor editAs="oneCell">` + from + to + string(shape) + `<xdr:clientData></xdr:clientData></xdr:twoCellAnchor>`)
+}
+
+// xfrmPlacement is the off and ext of a box, sheet EMU.
+func xfrmPlacement(box rect) string {
+	off, ext := emuPoint(point{box.x, box.y}), emuPoint(point{box.w, box.h})
+	return fmt.Sprintf(`<a:off x="%d" y="%d"></a:off><a:ext cx="%d" cy="%d"></a:ext>`, off[0], off[1], ext[0], ext[1])
+}
+
+func emuPoint(p point) [2]int {
+	return [2]int{int(math.Round(p.x * emuPerPixel)), int(math.Round(p.y * emuPerPixel))}
+}
+
+// connectorShape is the cxnSp replacing the sp of the line anchor, with the
+// line and style excelize wrote for it.
+func connectorShape(anchor []byte, id string, c *connectorItem, ids []string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<xdr:cxnSp macro=""><xdr:nvCxnSpPr><xdr:cNvPr id="%[1]s" name="Connector %[1]s"></xdr:cNvPr><xdr:cNvCxnSpPr>`, id)
+	if c.from >= 0 && ids[c.from] != "" {
+		fmt.Fprintf(&b, `<a:stCxn id="%s" idx="%d"></a:stCxn>`, ids[c.from], c.fromSite)
+	}
+	if c.to >= 0 && ids[c.to] != "" {
+		fmt.Fprintf(&b, `<a:endCxn id="%s" idx="%d"></a:endCxn>`, ids[c.to], c.toSite)
+	}
+	b.WriteString(`</xdr:cNvCxnSpPr></xdr:nvCxnSpPr><xdr:spPr>`)
+	b.WriteString(c.geometry.geometryXML())
+	b.Write(lineElemPattern.Find(anchor))
+	b.WriteString(`</xdr:spPr>`)
+	b.Write(stylePattern.Find(anchor))
+	b.WriteString(`</xdr:cxnSp>`)
+	return b.Bytes()
+}
+
+// anchorMarker is the xdr:from or xdr:to element of a point.
+func anchorMarker(name string, geom *sheetGeometry, p point) string {
+	col, row, offsetX, offsetY := geom.anchor(p)
+	return fmt.Sprintf("<xdr:%[1]s><xdr:col>%d</xdr:col><xdr:colOff>%d</xdr:colOff><xdr:row>%d</xdr:row><xdr:rowOff>%d</xdr:rowOff></xdr:%[1]s>",
+		name, col-1, int(math.Round(offsetX*emuPerPixel)), row-1, int(math.Round(offsetY*emuPerPixel)))
+}
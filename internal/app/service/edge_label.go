@@ -0,0 +1,176 @@
+package service
+
+import (
+	"go_excelize/internal/app/model"
+	"math"
+)
+
+// Edge labels, sizes in pixels.
+const (
+	edgeLabelMaxSize = 11.0 // points, smaller labels keep the node font size
+	edgeLabelColor   = "000000"
+	edgeLabelGap     = 4.0 // between the text and its line
+	edgeLabelStep    = 4.0 // how far a label slides along the line per try
+	lineClearance    = 2.0 // half a connector and a bit
+)
+
+// edgeLabel is the label of an edge, a box of text next to the line where it
+// leaves the source.
+type edgeLabel struct {
+	edge  model.Edge
+	box   rect
+	label labelFit
+}
+
+// placeEdgeLabels puts the label of every edge that has one next to the first
+// segment of its route, starting right at the source. Branches that leave
+// through the same corner run together for a while, their labels start where
+// they split. The label takes the first spot along the segment, on either
+// side, that touches no shape, line, arrowhead or other label. When there is
+// none it stays at the start.
+func placeEdgeLabels(routes []routedEdge, nodes []sceneNode, fontSize float64) []edgeLabel {
+	size := math.Min(fontSize, edgeLabelMaxSize)
+	var obstacles []rect
+	for _, n := range nodes {
+		obstacles = append(obstacles, n.box)
+	}
+	for _, r := range routes {
+		if len(r.points) < 2 {
+			continue
+		}
+		for i := 1; i < len(r.points); i++ {
+			obstacles = append(obstacles, segmentBox(r.points[i-1], r.points[i]).inflate(lineClearance))
+		}
+		head := arrowHead(r.points)
+		obstacles = append(obstacles, segmentBox(head[1], head[2]).union(segmentBox(head[0], head[0])))
+	}
+
+	var labels []edgeLabel
+	for _, r := range routes {
+		if r.edge.Label == "" || len(r.points) < 2 {
+			continue
+		}
+		fit := labelFit{lines: []string{r.edge.Label}, size: size}
+		w, h := textWidth(r.edge.Label, size)+2, lineHeight(size)
+		shared := 0.0
+		for _, other := range routes {
+			if other.edge != r.edge && other.edge.Source == r.edge.Source {
+				shared = math.Max(shared, sharedLength(r.points, other.points))
+			}
+		}
+		start, end := pointAlong(r.points, shared)
+		candidates := labelSpots(start, end, w, h)
+		box := candidates[0]
+		for _, c := range candidates {
+			if !overlapsAny(c, obstacles) {
+				box = c
+				break
+			}
+		}
+		obstacles = append(obstacles, box)
+		labels = append(labels, edgeLabel{edge: r.edge, box: box, label: fit})
+	}
+	return labels
+}
+
+// labelSpots are the boxes of w by h next to the segment from start to end,
+// nearest to the start first. A vertical segment has the label on its
+// right, then its left, a horizontal one above, then below.
+func labelSpots(start, end point, w, h float64) []rect {
+	vertical := start.x == end.x
+	length, extent := math.Abs(end.x-start.x), w
+	if vertical {
+		length, extent = math.Abs(end.y-start.y), h
+	}
+	var spots []rect
+	for t := edgeLabelGap; t == edgeLabelGap || t+extent <= length-edgeLabelGap; t += edgeLabelStep {
+		if vertical {
+			y := start.y + t
+			if end.y < start.y {
+				y = start.y - t - h
+			}
+			spots = append(spots, rect{start.x + edgeLabelGap, y, w, h}, rect{start.x - edgeLabelGap - w, y, w, h})
+		} else {
+			x := start.x + t
+			if end.x < start.x {
+				x = start.x - t - w
+			}
+			spots = append(spots, rect{x, start.y - edgeLabelGap - h, w, h}, rect{x, start.y + edgeLabelGap, w, h})
+		}
+	}
+	return spots
+}
+
+// sharedLength is how far two paths from the same point run on top of each
+// other. The paths are orthogonal, so they run together while they head the
+// same way.
+func sharedLength(a, b []point) float64 {
+	if len(a) < 2 || len(b) < 2 || a[0] != b[0] {
+		return 0
+	}
+	shared, at := 0.0, a[0]
+	for i, j := 1, 1; i < len(a) && j < len(b); {
+		da, db := heading(at, a[i]), heading(at, b[j])
+		if da != db {
+			break
+		}
+		step := math.Min(manhattan(at, a[i]), manhattan(at, b[j]))
+		at = point{at.x + da.x*step, at.y + da.y*step}
+		shared += step
+		if manhattan(at, a[i]) < 0.01 {
+			i++
+		}
+		if manhattan(at, b[j]) < 0.01 {
+			j++
+		}
+	}
+	return shared
+}
+
+// pointAlong is the point length along the path and the end of its segment.
+// At a bend it is the start of the next segment.
+func pointAlong(points []point, length float64) (point, point) {
+	for i := 1; i < len(points); i++ {
+		a, b := points[i-1], points[i]
+		d := manhattan(a, b)
+		if length < d-0.01 {
+			h := heading(a, b)
+			return point{a.x + h.x*length, a.y + h.y*length}, b
+		}
+		length -= d
+	}
+	return points[len(points)-2], points[len(points)-1]
+}
+
+// heading is the unit step from a toward b on an orthogonal path.
+func heading(a, b point) point {
+	sign := func(v float64) float64 {
+		switch {
+		case v > 0.01:
+			return 1
+		case v < -0.01:
+			return -1
+		}
+		return 0
+	}
+	return point{sign(b.x - a.x), sign(b.y - a.y)}
+}
+
+func manhattan(a, b point) float64 {
+	return math.Abs(b.x-a.x) + math.Abs(b.y-a.y)
+}
+
+// segmentBox is the box around the segment, flat for a straight one.
+func segmentBox(a, b point) rect {
+	x, y := math.Min(a.x, b.x), math.Min(a.y, b.y)
+	return rect{x, y, math.Abs(b.x - a.x), math.Abs(b.y - a.y)}
+}
+
+func overlapsAny(box rect, others []rect) bool {
+	for _, o := range others {
+		if box.overlaps(o) {
+			return true
+		}
+	}
+	return false
+}
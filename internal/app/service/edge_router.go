@@ -0,0 +1,559 @@
+package service
+
+import (
+	"container/heap"
+	"go_excelize/internal/app/model"
+	"math"
+	"sort"
+)
+
+// --- Orthogonal edge router ---
+//
+// Every edge becomes a polyline of horizontal and vertical segments that
+// leaves a side of the source box and enters a side of the target box
+// without going through any node. The candidate lines are the box centers,
+// the end of the port stubs, lanes in the channels between the boxes and
+// lanes around the whole chart. A* over their crossings prefers short paths
+// with few bends and pays extra to run on top of (or across) an edge routed
+// before, so parallel edges end up in lanes of their own.
+
+type side int
+
+// the order matches the A* directions: up, right, down, left
+const (
+	sideTop side = iota
+	sideRight
+	sideBottom
+	sideLeft
+)
+
+func (s side) opposite() side { return (s + 2) % 4 }
+
+// step is the unit vector pointing out of the box on that side.
+func (s side) step() (dx, dy int) {
+	switch s {
+	case sideTop:
+		return 0, -1
+	case sideRight:
+		return 1, 0
+	case sideBottom:
+		return 0, 1
+	default:
+		return -1, 0
+	}
+}
+
+// port is the middle of the side of the box.
+func (r rect) port(s side) point {
+	c := r.center()
+	switch s {
+	case sideTop:
+		return point{c.x, r.y}
+	case sideRight:
+		return point{r.right(), c.y}
+	case sideBottom:
+		return point{c.x, r.bottom()}
+	default:
+		return point{r.x, c.y}
+	}
+}
+
+const (
+	routeClearance = 3.0  // obstacles are the node boxes grown by this
+	laneSpacing    = 8.0  // distance between two parallel lanes
+	maxStubLength  = 12.0 // straight part leaving and entering a box
+	maxOuterLanes  = 16   // lanes around the chart for the long back edges
+	bendCost       = 40.0
+	overlapCost    = 400.0
+	crossCost      = 20.0
+)
+
+// routedEdge is the path of one edge, from the port of the source to the port
+// of the target (see shapePorts).
+type routedEdge struct {
+	edge        model.Edge
+	points      []point
+	exit, entry side // sides of the source and target the path starts and ends on
+}
+
+// routeJob is an edge waiting to be routed.
+type routeJob struct {
+	edge        model.Edge
+	from, to    point // ports on the outlines
+	start, goal point // ends of the stubs leaving from and entering to
+	exit, entry side
+	back        bool
+	span        float64
+	path        []point
+}
+
+// sharesEnd tells if two edges leave or enter through the same port, their
+// paths may then run together near that port.
+func (j *routeJob) sharesEnd(other *routeJob) bool {
+	return (j.from == other.from && j.exit == other.exit) || (j.to == other.to && j.entry == other.entry)
+}
+
+// routeEdges routes every edge between the node boxes, pad is the space
+// between two boxes.
+func routeEdges(flow *model.Flowchart, boxes map[string]rect, pad float64) []routedEdge {
+	// the branches of a node with more than two leave toward their target,
+	// only the one straight below leaves from the bottom
+	branches := make(map[string]int)
+	for _, edge := range flow.Edges {
+		branches[edge.Source]++
+	}
+	types := make(map[string]string, len(flow.Nodes))
+	noted := make(map[string]bool)
+	for _, node := range flow.Nodes {
+		types[node.ID] = node.Type
+		noted[node.ID] = node.Note != "" && !model.IsBar(node.Type)
+	}
+	stub := math.Max(math.Min(pad/2, maxStubLength), routeClearance+1)
+	var jobs []*routeJob
+	for _, edge := range flow.Edges {
+		from, ok1 := boxes[edge.Source]
+		to, ok2 := boxes[edge.Target]
+		if !ok1 || !ok2 {
+			continue
+		}
+		// the sides are picked as if the chart ran down, then turned to
+		// the direction of the chart
+		dir := flow.Layout.Direction
+		fromF, toF := rectToFlow(dir, from), rectToFlow(dir, to)
+		sideways := edge.Branch == model.BranchFalse || branches[edge.Source] > 2 && toF.center().x != fromF.center().x
+		exitF, entryF := chooseSides(fromF, toF, sideways)
+		exitF, entryF = avoidNotes(exitF, entryF, fromF, toF, noted[edge.Source], noted[edge.Target])
+		exit, entry := sideFromFlow(dir, exitF), sideFromFlow(dir, entryF)
+		job := &routeJob{
+			edge:  edge,
+			exit:  exit,
+			entry: entry,
+			from:  portOn(types[edge.Source], from, exit).at,
+			to:    portOn(types[edge.Target], to, entry).at,
+			back:  toF.bottom() <= fromF.y || exit == entry,
+		}
+		// a bar is met straight across from the shape on the other end
+		if model.IsBar(types[edge.Source]) && (exitF == sideTop || exitF == sideBottom) {
+			job.from = alongBar(job.from, from, to.center())
+		}
+		if model.IsBar(types[edge.Target]) && (entryF == sideTop || entryF == sideBottom) {
+			job.to = alongBar(job.to, to, from.center())
+		}
+		// the stubs end clear of the box, wherever the port is on the outline
+		job.start = stubEnd(job.from, from, exit, stub)
+		job.goal = stubEnd(job.to, to, entry, stub)
+		job.span = math.Abs(job.to.x-job.from.x) + math.Abs(job.to.y-job.from.y)
+		jobs = append(jobs, job)
+	}
+
+	r := newRouter(boxes, stub, min(len(jobs), maxOuterLanes))
+
+	// the short forward edges take the straight lines first, the back edges
+	// come last (shortest first) and take the lanes that are left
+	order := make([]*routeJob, len(jobs))
+	copy(order, jobs)
+	sort.SliceStable(order, func(a, b int) bool {
+		if order[a].back != order[b].back {
+			return !order[a].back
+		}
+		return order[a].span < order[b].span
+	})
+	for _, job := range order {
+		r.route(job)
+	}
+
+	routes := make([]routedEdge, 0, len(jobs))
+	for _, job := range jobs {
+		routes = append(routes, routedEdge{edge: job.edge, points: job.path, exit: job.exit, entry: job.entry})
+	}
+	return routes
+}
+
+// chooseSides picks the side an edge leaves the source and enters the target.
+// Forward edges go from the bottom to the top, edges in the same row go side
+// to side and edges going up loop around on the right (or left). A false
+// branch always leaves sideways, toward its target.
+func chooseSides(from, to rect, sideways bool) (exit, entry side) {
+	below := to.y >= from.bottom()
+	above := to.bottom() <= from.y
+	toRight := to.center().x >= from.center().x
+
+	exit = sideLeft
+	if toRight {
+		exit = sideRight
+	}
+	switch {
+	case below && sideways:
+		return exit, sideTop
+	case below:
+		return sideBottom, sideTop
+	case above:
+		// enter from the facing side if the target is clear of the source,
+		// otherwise loop around and come back in from the same side
+		if exit == sideRight && to.x > from.right() || exit == sideLeft && to.right() < from.x {
+			return exit, exit.opposite()
+		}
+		if !sideways {
+			exit = sideRight
+		}
+		return exit, exit
+	default:
+		return exit, exit.opposite()
+	}
+}
+
+// avoidNotes moves the ends of an edge off the left side of a shape with a
+// note, the side its dashed link leaves from (in the flow frame, see
+// sceneNote). A loop goes around the right instead, an edge leaves from the
+// bottom (the right when going up) and comes in from the top (the bottom
+// when coming from below).
+func avoidNotes(exit, entry side, from, to rect, fromNote, toNote bool) (side, side) {
+	if exit == sideLeft && entry == sideLeft && (fromNote || toNote) {
+		return sideRight, sideRight
+	}
+	above := to.bottom() <= from.y
+	if fromNote && exit == sideLeft {
+		exit = sideBottom
+		if above {
+			exit = sideRight
+		}
+	}
+	if toNote && entry == sideLeft {
+		entry = sideTop
+		if above {
+			entry = sideBottom
+		}
+	}
+	return exit, entry
+}
+
+// alongBar slides the port along the bar to be in line with the point, as
+// far as the bar goes.
+func alongBar(port point, bar rect, p point) point {
+	if bar.w >= bar.h {
+		port.x = math.Min(math.Max(p.x, bar.x), bar.right())
+	} else {
+		port.y = math.Min(math.Max(p.y, bar.y), bar.bottom())
+	}
+	return port
+}
+
+// stubEnd is where the stub from the port ends, stub out of the side of the
+// box in line with the port.
+func stubEnd(port point, box rect, s side, stub float64) point {
+	dx, dy := s.step()
+	end := box.port(s)
+	if dx == 0 {
+		end.x = port.x
+	} else {
+		end.y = port.y
+	}
+	return point{end.x + float64(dx)*stub, end.y + float64(dy)*stub}
+}
+
+// --- Routing grid ---
+
+type router struct {
+	xs, ys   []float64
+	hBlocked []bool // stretch from (ix, iy) to (ix+1, iy) goes through a node
+	vBlocked []bool // stretch from (ix, iy) to (ix, iy+1) goes through a node
+
+	// what the routed edges use: the stretches they run on and the points
+	// they pass straight through, by the index of the stretch or point
+	hUsed, vUsed       map[int][]*routeJob
+	hThrough, vThrough map[int][]*routeJob
+}
+
+func newRouter(boxes map[string]rect, stub float64, outerLanes int) *router {
+	var xRanges, yRanges [][2]float64
+	var xs, ys []float64
+	obstacles := make([]rect, 0, len(boxes))
+	for _, box := range boxes {
+		xRanges = append(xRanges, [2]float64{box.x, box.right()})
+		yRanges = append(yRanges, [2]float64{box.y, box.bottom()})
+		c := box.center()
+		xs = append(xs, box.x-stub, c.x, box.right()+stub)
+		ys = append(ys, box.y-stub, c.y, box.bottom()+stub)
+		obstacles = append(obstacles, box.inflate(routeClearance))
+	}
+	r := &router{
+		xs:       uniqueSorted(append(xs, laneCoords(xRanges, stub, outerLanes)...)),
+		ys:       uniqueSorted(append(ys, laneCoords(yRanges, stub, outerLanes)...)),
+		hUsed:    make(map[int][]*routeJob),
+		vUsed:    make(map[int][]*routeJob),
+		hThrough: make(map[int][]*routeJob),
+		vThrough: make(map[int][]*routeJob),
+	}
+
+	r.hBlocked = make([]bool, len(r.xs)*len(r.ys))
+	r.vBlocked = make([]bool, len(r.xs)*len(r.ys))
+	for ix := range r.xs {
+		for iy := range r.ys {
+			x, y := r.xs[ix], r.ys[iy]
+			for _, o := range obstacles {
+				if ix+1 < len(r.xs) && y > o.y && y < o.bottom() && r.xs[ix+1] > o.x && x < o.right() {
+					r.hBlocked[r.key(ix, iy)] = true
+				}
+				if iy+1 < len(r.ys) && x > o.x && x < o.right() && r.ys[iy+1] > o.y && y < o.bottom() {
+					r.vBlocked[r.key(ix, iy)] = true
+				}
+			}
+		}
+	}
+	return r
+}
+
+// laneCoords returns the lanes of one axis: a few parallel lanes in every
+// channel between the boxes and outerLanes lanes before and after all of them.
+func laneCoords(ranges [][2]float64, stub float64, outerLanes int) []float64 {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	merged := [][2]float64{ranges[0]}
+	for _, rg := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if rg[0] <= last[1]+2*routeClearance {
+			last[1] = math.Max(last[1], rg[1])
+			continue
+		}
+		merged = append(merged, rg)
+	}
+
+	var lanes []float64
+	for i := 1; i < len(merged); i++ {
+		lo, hi := merged[i-1][1]+routeClearance, merged[i][0]-routeClearance
+		mid := (lo + hi) / 2
+		lanes = append(lanes, mid)
+		for d := laneSpacing; mid-d > lo; d += laneSpacing {
+			lanes = append(lanes, mid-d, mid+d)
+		}
+	}
+	first, last := merged[0][0], merged[len(merged)-1][1]
+	for i := 0; i < outerLanes; i++ {
+		d := stub + float64(i)*laneSpacing
+		if first-d >= 1 {
+			lanes = append(lanes, first-d)
+		}
+		lanes = append(lanes, last+d)
+	}
+	return lanes
+}
+
+func uniqueSorted(values []float64) []float64 {
+	sort.Float64s(values)
+	out := values[:0]
+	for _, v := range values {
+		if v < 0 {
+			continue
+		}
+		if len(out) == 0 || v-out[len(out)-1] > 0.01 {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func (r *router) key(ix, iy int) int { return ix*len(r.ys) + iy }
+
+func indexOf(values []float64, v float64) int {
+	i := sort.SearchFloat64s(values, v-0.01)
+	if i < len(values) && math.Abs(values[i]-v) <= 0.01 {
+		return i
+	}
+	return -1
+}
+
+// --- A* search ---
+
+type searchItem struct {
+	state int
+	cost  float64
+}
+
+type searchQueue []searchItem
+
+func (q searchQueue) Len() int           { return len(q) }
+func (q searchQueue) Less(i, j int) bool { return q[i].cost < q[j].cost }
+func (q searchQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *searchQueue) Push(x any)        { *q = append(*q, x.(searchItem)) }
+func (q *searchQueue) Pop() any {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// route finds the path of the job and records it as used.
+func (r *router) route(job *routeJob) {
+	start, goal := job.start, job.goal
+
+	cells := r.search(job, start, goal)
+	if cells == nil {
+		// no way around the nodes, fall back to a plain elbow
+		job.path = simplifyPath([]point{job.from, start, {start.x, goal.y}, goal, job.to})
+		return
+	}
+	r.markUsed(job, cells)
+
+	path := []point{job.from}
+	for _, c := range cells {
+		path = append(path, point{r.xs[c[0]], r.ys[c[1]]})
+	}
+	job.path = simplifyPath(append(path, job.to))
+}
+
+// search runs A* from start to goal over the grid. A state is a grid point
+// and the direction the path arrived in, so bends can be paid for.
+func (r *router) search(job *routeJob, start, goal point) [][2]int {
+	sx, sy := indexOf(r.xs, start.x), indexOf(r.ys, start.y)
+	gx, gy := indexOf(r.xs, goal.x), indexOf(r.ys, goal.y)
+	if sx < 0 || sy < 0 || gx < 0 || gy < 0 {
+		return nil
+	}
+	arrive := int(job.entry.opposite()) // moving into the target box
+
+	states := len(r.xs) * len(r.ys) * 4
+	cost := make([]float64, states)
+	prev := make([]int, states)
+	for i := range cost {
+		cost[i] = math.Inf(1)
+		prev[i] = -1
+	}
+	heuristic := func(ix, iy int) float64 {
+		return math.Abs(r.xs[ix]-goal.x) + math.Abs(r.ys[iy]-goal.y)
+	}
+
+	first := r.key(sx, sy)*4 + int(job.exit)
+	cost[first] = 0
+	queue := &searchQueue{{first, heuristic(sx, sy)}}
+	done := make([]bool, states)
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(searchItem)
+		state := item.state
+		if done[state] {
+			continue
+		}
+		done[state] = true
+		cell, dir := state/4, state%4
+		ix, iy := cell/len(r.ys), cell%len(r.ys)
+		if ix == gx && iy == gy {
+			var cells [][2]int
+			for s := state; s != -1; s = prev[s] {
+				c := s / 4
+				cells = append(cells, [2]int{c / len(r.ys), c % len(r.ys)})
+			}
+			for i, j := 0, len(cells)-1; i < j; i, j = i+1, j-1 {
+				cells[i], cells[j] = cells[j], cells[i]
+			}
+			return cells
+		}
+
+		for next := 0; next < 4; next++ {
+			if next == (dir+2)%4 {
+				continue // no U-turn
+			}
+			dx, dy := side(next).step()
+			nx, ny := ix+dx, iy+dy
+			if nx < 0 || ny < 0 || nx >= len(r.xs) || ny >= len(r.ys) {
+				continue
+			}
+			step, ok := r.stepCost(job, ix, iy, nx, ny)
+			if !ok {
+				continue
+			}
+			if next != dir {
+				step += bendCost
+			}
+			if nx == gx && ny == gy && next != arrive {
+				step += bendCost // one more bend into the target
+				if next == (arrive+2)%4 {
+					step += bendCost
+				}
+			}
+			ns := r.key(nx, ny)*4 + next
+			if c := cost[state] + step; c < cost[ns] {
+				cost[ns] = c
+				prev[ns] = state
+				heap.Push(queue, searchItem{ns, c + heuristic(nx, ny)})
+			}
+		}
+	}
+	return nil
+}
+
+// stepCost is the cost to move between two neighbour grid points, false if
+// a node is in the way.
+func (r *router) stepCost(job *routeJob, ix, iy, nx, ny int) (float64, bool) {
+	var blocked bool
+	var used, crossed []*routeJob
+	if iy == ny {
+		k := r.key(min(ix, nx), iy)
+		blocked, used, crossed = r.hBlocked[k], r.hUsed[k], r.vThrough[r.key(nx, ny)]
+	} else {
+		k := r.key(ix, min(iy, ny))
+		blocked, used, crossed = r.vBlocked[k], r.vUsed[k], r.hThrough[r.key(nx, ny)]
+	}
+	if blocked {
+		return 0, false
+	}
+	cost := math.Abs(r.xs[nx]-r.xs[ix]) + math.Abs(r.ys[ny]-r.ys[iy])
+	if usedByOther(job, used) {
+		cost += overlapCost
+	}
+	if usedByOther(job, crossed) {
+		cost += crossCost
+	}
+	return cost, true
+}
+
+func usedByOther(job *routeJob, jobs []*routeJob) bool {
+	for _, other := range jobs {
+		if !job.sharesEnd(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// markUsed records the stretches and the straight-through points of a path.
+func (r *router) markUsed(job *routeJob, cells [][2]int) {
+	for i := 1; i < len(cells); i++ {
+		a, b := cells[i-1], cells[i]
+		horizontal := a[1] == b[1]
+		if horizontal {
+			k := r.key(min(a[0], b[0]), a[1])
+			r.hUsed[k] = append(r.hUsed[k], job)
+		} else {
+			k := r.key(a[0], min(a[1], b[1]))
+			r.vUsed[k] = append(r.vUsed[k], job)
+		}
+		if i+1 < len(cells) && (cells[i+1][1] == b[1]) == horizontal {
+			k := r.key(b[0], b[1])
+			if horizontal {
+				r.hThrough[k] = append(r.hThrough[k], job)
+			} else {
+				r.vThrough[k] = append(r.vThrough[k], job)
+			}
+		}
+	}
+}
+
+// simplifyPath drops repeated points and the middle of straight runs.
+func simplifyPath(points []point) []point {
+	var out []point
+	for _, p := range points {
+		if n := len(out); n > 0 && math.Abs(out[n-1].x-p.x) < 0.01 && math.Abs(out[n-1].y-p.y) < 0.01 {
+			continue
+		}
+		if n := len(out); n >= 2 {
+			a, b := out[n-2], out[n-1]
+			if (math.Abs(a.x-b.x) < 0.01 && math.Abs(b.x-p.x) < 0.01) || (math.Abs(a.y-b.y) < 0.01 && math.Abs(b.y-p.y) < 0.01) {
+				out[n-1] = p
+				continue
+			}
+		}
+		out = append(out, p)
+	}
+	return out
+}
@@ -1,9 +1,14 @@
 package service
 
 import (
+	"fmt"
 	"go_excelize/internal/app/model"
+
+	"github.com/xuri/excelize/v2"
 )
 
+const sheetName = "Sheet1"
+
 type ExcelService struct {
 	excels []model.Input
 }
@@ -17,3 +22,231 @@ func NewExcelService() *ExcelService {
 		},
 	}
 }
+
+// GenerateFlowchart draws the flowchart into a new workbook. The flowchart is
+// expected to be validated already (see model.Flowchart.Validate).
+func (s *ExcelService) GenerateFlowchart(flow *model.Flowchart) (*excelize.File, error) {
+	file := excelize.NewFile()
+	if err := s.DrawFlowchart(file, sheetName, flow); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// DrawFlowchart draws the flowchart on a sheet of an existing workbook, next
+// to whatever the sheet already has. The layout works in pixels from the top
+// left of the start cell, every shape and line is then anchored to the cell
+// under it with the real column widths and row heights of the sheet.
+func (s *ExcelService) DrawFlowchart(file *excelize.File, sheet string, flow *model.Flowchart) error {
+	layout := flow.Layout
+	startColNum, startRowNum, err := excelize.CellNameToCoordinates(layout.Start)
+	if err != nil {
+		return fmt.Errorf("invalid start cell %q: %w", layout.Start, err)
+	}
+	geom := newSheetGeometry(file, sheet)
+	var anchors []anchorItem
+
+	// resizing only touches the cells from the start cell on, so the start
+	// cell is where it is before and after
+	sc := layoutScene(flow, point{geom.colX(startColNum), geom.rowY(startRowNum)})
+
+	// resizing makes the cells line up with the slots, without it the sheet
+	// keeps its sizes and only the anchors follow them
+	if layout.ResizeCells == nil || *layout.ResizeCells {
+		if err := checkSheetBounds(startColNum+len(sc.colWidths)-1, startRowNum+len(sc.rowHeights)-1, layout.Start); err != nil {
+			return err
+		}
+		for col, width := range sc.colWidths {
+			if err := geom.setColWidth(startColNum+col, width); err != nil {
+				return err
+			}
+		}
+		for row, height := range sc.rowHeights {
+			if err := geom.setRowHeight(startRowNum+row, height); err != nil {
+				return err
+			}
+		}
+	}
+
+	// --- Add everything back to front, Excel stacks the shapes in this order ---
+	nodes := make(map[string]*sceneNode, len(sc.nodes))
+	for i := range sc.nodes {
+		nodes[sc.nodes[i].node.ID] = &sc.nodes[i]
+	}
+	nodeItems := make(map[string]int, len(sc.nodes))
+	var edgeItems, labelItems []edgeItem
+	var laneItems []int
+	noteItems := make(map[string][]int)
+	for _, d := range sc.drawables() {
+		switch {
+		case d.lane != nil:
+			l := d.lane
+			for _, part := range []struct {
+				box   rect
+				label labelFit
+				fill  string
+			}{{l.band, labelFit{}, l.fill()}, {l.header, l.label, laneHeaderColor}} {
+				if err := checkBoxOnSheet(geom, part.box, layout.Start); err != nil {
+					return err
+				}
+				col, row, offsetX, offsetY := geom.anchor(point{part.box.x, part.box.y})
+				cell, err := excelize.CoordinatesToCellName(col, row)
+				if err != nil {
+					return fmt.Errorf("lane %q: %w", l.name, err)
+				}
+				if err := file.AddShape(sheet, laneShape(cell, part.box, part.label, part.fill, int(offsetX), int(offsetY))); err != nil {
+					return fmt.Errorf("lane %q: %w", l.name, err)
+				}
+				laneItems = append(laneItems, len(anchors))
+				anchors = append(anchors, anchorItem{box: part.box, centerText: true})
+			}
+
+		case d.node != nil:
+			n := d.node
+			if err := checkBoxOnSheet(geom, n.box, layout.Start); err != nil {
+				return err
+			}
+			col, row, offsetX, offsetY := geom.anchor(point{n.box.x, n.box.y})
+			cell, err := excelize.CoordinatesToCellName(col, row)
+			if err != nil {
+				return fmt.Errorf("node %q: %w", n.node.ID, err)
+			}
+			shape := newFlowchartShape(cell, presetOf(n.node.Type), n.label, uint(n.box.w), uint(n.box.h), int(offsetX), int(offsetY))
+			applyNodeStyle(shape, n.node)
+			if err := file.AddShape(sheet, shape); err != nil {
+				return fmt.Errorf("node %q: %w", n.node.ID, err)
+			}
+			nodeItems[n.node.ID] = len(anchors)
+			anchors = append(anchors, anchorItem{box: n.box, centerText: true})
+
+		case d.route != nil:
+			route := d.route
+			// a lane around the chart may still run off the last column or row
+			for _, p := range route.points {
+				if err := checkBoxOnSheet(geom, rect{x: p.x, y: p.y}, layout.Start); err != nil {
+					return err
+				}
+			}
+			shape, item := newConnectorShape(*route, *nodes[route.edge.Source], *nodes[route.edge.Target], geom)
+			if err := file.AddShape(sheet, shape); err != nil {
+				return fmt.Errorf("edge %s->%s: %w", route.edge.Source, route.edge.Target, err)
+			}
+			edgeItems = append(edgeItems, edgeItem{route.edge, len(anchors)})
+			anchors = append(anchors, item)
+
+		case d.label != nil:
+			l := d.label
+			if err := checkBoxOnSheet(geom, l.box, layout.Start); err != nil {
+				return err
+			}
+			col, row, offsetX, offsetY := geom.anchor(point{l.box.x, l.box.y})
+			cell, err := excelize.CoordinatesToCellName(col, row)
+			if err != nil {
+				return fmt.Errorf("label of edge %s->%s: %w", l.edge.Source, l.edge.Target, err)
+			}
+			shape := newEdgeLabelShape(cell, l.label, uint(l.box.w), uint(l.box.h), int(offsetX), int(offsetY))
+			if err := file.AddShape(sheet, shape); err != nil {
+				return fmt.Errorf("label of edge %s->%s: %w", l.edge.Source, l.edge.Target, err)
+			}
+			labelItems = append(labelItems, edgeItem{l.edge, len(anchors)})
+			anchors = append(anchors, anchorItem{box: l.box, centerText: true, textOnly: true})
+
+		case d.note != nil:
+			n := d.note
+			if err := checkBoxOnSheet(geom, n.box, layout.Start); err != nil {
+				return err
+			}
+			shapes, items := newNoteShapes(*n, *nodes[n.node], nodeItems[n.node], geom)
+			for i, shape := range shapes {
+				if err := file.AddShape(sheet, shape); err != nil {
+					return fmt.Errorf("note of node %q: %w", n.node, err)
+				}
+				noteItems[n.node] = append(noteItems[n.node], len(anchors))
+				anchors = append(anchors, items[i])
+			}
+		}
+	}
+	// the connectors go in before the shapes they join, bind them now that
+	// every shape has its item
+	for _, e := range edgeItems {
+		c := anchors[e.item].connector
+		c.from, c.to = nodeItems[e.edge.Source], nodeItems[e.edge.Target]
+	}
+
+	groups := chartGroups(flow, nodeItems, noteItems, append(edgeItems, labelItems...), laneItems)
+	return patchDrawings(file, placeAnchors(anchors, groups, geom))
+}
+
+// edgeItem is an anchor item drawn for an edge, its connector or its label.
+type edgeItem struct {
+	edge model.Edge
+	item int
+}
+
+// chartGroups makes a group shape for every node group. It holds the nodes
+// of the group with their notes and the connectors between them, with their
+// labels. With
+// layout.group the whole chart goes in one more group around them. That
+// group also takes the swimlanes and everything outside a node group.
+func chartGroups(flow *model.Flowchart, nodeItems map[string]int, noteItems map[string][]int, edgeItems []edgeItem, laneItems []int) []*anchorGroup {
+	var groups []*anchorGroup
+	byName := map[string]*anchorGroup{}
+	loose := append([]int(nil), laneItems...)
+	for _, node := range flow.Nodes {
+		items := append([]int{nodeItems[node.ID]}, noteItems[node.ID]...)
+		if node.Group == "" {
+			loose = append(loose, items...)
+			continue
+		}
+		g, ok := byName[node.Group]
+		if !ok {
+			g = &anchorGroup{name: node.Group}
+			byName[node.Group] = g
+			groups = append(groups, g)
+		}
+		g.items = append(g.items, items...)
+	}
+	groupOf := make(map[string]string, len(flow.Nodes))
+	for _, node := range flow.Nodes {
+		groupOf[node.ID] = node.Group
+	}
+	for _, e := range edgeItems {
+		if name := groupOf[e.edge.Source]; name != "" && name == groupOf[e.edge.Target] {
+			byName[name].items = append(byName[name].items, e.item)
+		} else {
+			loose = append(loose, e.item)
+		}
+	}
+
+	if !flow.Layout.Group {
+		return groups
+	}
+	return []*anchorGroup{{name: "Flowchart", items: loose, groups: groups}}
+}
+
+// checkBoxOnSheet fails when the bottom right corner of the box is past the
+// last column or row of the sheet.
+func checkBoxOnSheet(geom *sheetGeometry, box rect, start string) error {
+	col, row, _, _ := geom.anchor(point{box.right(), box.bottom()})
+	return checkSheetBounds(col, row, start)
+}
+
+// checkSheetBounds fails when the chart reaches past the last column (XFD)
+// or the last row of a sheet. It is reported as a bad start cell, which is
+// the field that moves the chart back onto the sheet.
+func checkSheetBounds(col, row int, start string) error {
+	if col > excelize.MaxColumns {
+		return model.ValidationErrors{{
+			Field:   "layout.start",
+			Message: fmt.Sprintf("starting at %s the flowchart needs column %d, a sheet has %d columns", start, col, excelize.MaxColumns),
+		}}
+	}
+	if row > excelize.TotalRows {
+		return model.ValidationErrors{{
+			Field:   "layout.start",
+			Message: fmt.Sprintf("starting at %s the flowchart needs row %d, a sheet has %d rows", start, row, excelize.TotalRows),
+		}}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"go_excelize/internal/app/model"
+	"math"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Default look of the flowchart, shared by every output.
+const (
+	nodeFont           = "Times New Roman"
+	nodeFontColor      = "777777"
+	nodeLineColor      = "060270"
+	nodeFillColor      = "FFFFFF"
+	nodeLineWidth      = 1.2 // points
+	connectorColor     = "000000"
+	connectorLineWidth = 1.5
+)
+
+// nodeColors is the fill, line and font color of a node with its style. A
+// bar is filled with its line color.
+func nodeColors(node model.Node) (fill, line, font string) {
+	fill, line, font = nodeFillColor, nodeLineColor, nodeFontColor
+	if style := node.Style; style != nil {
+		if style.Fill != "" {
+			fill = strings.TrimPrefix(style.Fill, "#")
+		}
+		if style.Line != "" {
+			line = strings.TrimPrefix(style.Line, "#")
+		}
+		if style.FontColor != "" {
+			font = strings.TrimPrefix(style.FontColor, "#")
+		}
+	}
+	if model.IsBar(node.Type) && (node.Style == nil || node.Style.Fill == "") {
+		fill = line
+	}
+	return
+}
+
+// presetOf is the excelize shape type of a node type, a bar is a rectangle.
+func presetOf(nodeType string) string {
+	if model.IsBar(nodeType) {
+		return "rect"
+	}
+	return nodeType
+}
+
+// Pass the text in, but not the cell dimensions. Every line of the label is
+// its own paragraph, excelize writes the text without wrapping.
+func newFlowchartShape(cell, shapeType string, label labelFit, width, height uint, offsetX, offsetY int) *excelize.Shape {
+	lineWidth := nodeLineWidth
+	paragraphs := make([]excelize.RichTextRun, 0, len(label.lines))
+	for _, line := range label.lines {
+		paragraphs = append(paragraphs, excelize.RichTextRun{
+			Text: line,
+			Font: &excelize.Font{
+				Bold:   false,
+				Italic: false,
+				Family: nodeFont,
+				Size:   label.size,
+				Color:  nodeFontColor,
+			},
+		})
+	}
+	return &excelize.Shape{
+		Cell:      cell,
+		Type:      shapeType,
+		Line:      excelize.ShapeLine{Color: nodeLineColor, Width: &lineWidth},
+		Fill:      excelize.Fill{Color: []string{nodeFillColor}, Pattern: 1},
+		Paragraph: paragraphs,
+		Width:     width,
+		Height:    height,
+		Format: excelize.GraphicOptions{
+			Positioning: "oneCell", // "Move but do not size with cells"
+			OffsetX:     offsetX,
+			OffsetY:     offsetY,
+		},
+	}
+}
+
+// newEdgeLabelShape is the text box of an edge label. The patch takes away
+// its fill, outline and insets, so only the text shows.
+func newEdgeLabelShape(cell string, label labelFit, width, height uint, offsetX, offsetY int) *excelize.Shape {
+	lineWidth := 1.0
+	return &excelize.Shape{
+		Cell: cell,
+		Type: "rect",
+		Line: excelize.ShapeLine{Width: &lineWidth},
+		Paragraph: []excelize.RichTextRun{{
+			Text: label.lines[0],
+			Font: &excelize.Font{Family: nodeFont, Size: label.size, Color: edgeLabelColor},
+		}},
+		Width:  width,
+		Height: height,
+		Format: excelize.GraphicOptions{
+			Positioning: "oneCell",
+			OffsetX:     offsetX,
+			OffsetY:     offsetY,
+		},
+	}
+}
+
+// applyNodeStyle gives the shape the colors of the node, see nodeColors.
+func applyNodeStyle(shape *excelize.Shape, node model.Node) {
+	fill, line, font := nodeColors(node)
+	shape.Fill.Color = []string{fill}
+	shape.Line.Color = line
+	for i := range shape.Paragraph {
+		shape.Paragraph[i].Font.Color = font
+	}
+}
+
+// newConnectorShape adds the line of a routed edge, placed on the sheet and
+// turned into a connector when the drawing is patched. The items of the
+// shapes it joins are set by the caller.
+func newConnectorShape(route routedEdge, from, to sceneNode, geom *sheetGeometry) (*excelize.Shape, anchorItem) {
+	lineWidth := connectorLineWidth
+	g := connectorFor(route.points)
+	box := g.anchorBox()
+	col, row, offsetX, offsetY := geom.anchor(point{box.x, box.y})
+	cell, _ := excelize.CoordinatesToCellName(col, row)
+	shape := &excelize.Shape{
+		Cell:   cell,
+		Type:   "line",
+		Line:   excelize.ShapeLine{Color: connectorColor, Width: &lineWidth},
+		Width:  uint(math.Max(box.w, 1)),
+		Height: uint(math.Max(box.h, 1)),
+		Format: excelize.GraphicOptions{
+			OffsetX: int(offsetX),
+			OffsetY: int(offsetY),
+		},
+	}
+	return shape, anchorItem{
+		box:   box,
+		arrow: true,
+		connector: &connectorItem{
+			geometry: g,
+			fromSite: portOn(from.node.Type, from.box, route.exit).site,
+			toSite:   portOn(to.node.Type, to.box, route.entry).site,
+		},
+	}
+}
+
+// newNoteShapes adds the annotation of a node: the dashed link glued to the
+// node item, the bracket and the text box without fill or outline. The
+// items come in the same order as the shapes.
+func newNoteShapes(note sceneNote, node sceneNode, nodeItem int, geom *sheetGeometry) ([]*excelize.Shape, []anchorItem) {
+	lineWidth := noteLineWidth
+	line := func(g connectorGeometry) *excelize.Shape {
+		box := g.anchorBox()
+		col, row, offsetX, offsetY := geom.anchor(point{box.x, box.y})
+		cell, _ := excelize.CoordinatesToCellName(col, row)
+		return &excelize.Shape{
+			Cell:   cell,
+			Type:   "line",
+			Line:   excelize.ShapeLine{Color: noteLineColor, Width: &lineWidth},
+			Width:  uint(math.Max(box.w, 1)),
+			Height: uint(math.Max(box.h, 1)),
+			Format: excelize.GraphicOptions{OffsetX: int(offsetX), OffsetY: int(offsetY)},
+		}
+	}
+	link := connectorFor(note.link[:])
+	bracket := customConnector(note.bracket())
+
+	paragraphs := make([]excelize.RichTextRun, 0, len(note.label.lines))
+	for _, text := range note.label.lines {
+		paragraphs = append(paragraphs, excelize.RichTextRun{
+			Text: text,
+			Font: &excelize.Font{Family: nodeFont, Size: note.label.size, Color: noteFontColor},
+		})
+	}
+	col, row, offsetX, offsetY := geom.anchor(point{note.box.x, note.box.y})
+	cell, _ := excelize.CoordinatesToCellName(col, row)
+	text := &excelize.Shape{
+		Cell:      cell,
+		Type:      "rect",
+		Line:      excelize.ShapeLine{Width: &lineWidth},
+		Paragraph: paragraphs,
+		Width:     uint(note.box.w),
+		Height:    uint(note.box.h),
+		Format: excelize.GraphicOptions{
+			Positioning: "oneCell",
+			OffsetX:     int(offsetX),
+			OffsetY:     int(offsetY),
+		},
+	}
+
+	return []*excelize.Shape{line(link), line(bracket), text}, []anchorItem{
+		{box: link.anchorBox(), dashed: true, connector: &connectorItem{
+			geometry: link,
+			from:     nodeItem,
+			to:       -1,
+			fromSite: portOn(node.node.Type, node.box, note.side.opposite()).site,
+		}},
+		{box: bracket.anchorBox(), connector: &connectorItem{geometry: bracket, from: -1, to: -1}},
+		{box: note.box, centerText: true, textOnly: true},
+	}
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"math"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Default size of a column and a row that was never resized, in pixels
+// (8.43 characters of Calibri 11 and 15 points). maxDigitWidth is the width
+// of a digit of Calibri 11, the unit of the column widths.
+const (
+	defaultColPixels = 64.0
+	defaultRowPixels = 20.0
+	maxDigitWidth    = 7.0
+	emuPerPixel      = 9525
+)
+
+type point struct {
+	x, y float64
+}
+
+// rect is a box in pixels, x and y are the top left corner.
+type rect struct {
+	x, y, w, h float64
+}
+
+func (r rect) right() float64  { return r.x + r.w }
+func (r rect) bottom() float64 { return r.y + r.h }
+func (r rect) center() point   { return point{r.x + r.w/2, r.y + r.h/2} }
+
+// inflate grows the box by d on every side.
+func (r rect) inflate(d float64) rect {
+	return rect{r.x - d, r.y - d, r.w + 2*d, r.h + 2*d}
+}
+
+// union is the box around both.
+func (r rect) union(o rect) rect {
+	x, y := math.Min(r.x, o.x), math.Min(r.y, o.y)
+	return rect{x, y, math.Max(r.right(), o.right()) - x, math.Max(r.bottom(), o.bottom()) - y}
+}
+
+// overlaps tells if the boxes share some area or touch.
+func (r rect) overlaps(o rect) bool {
+	return r.x <= o.right() && o.x <= r.right() && r.y <= o.bottom() && o.y <= r.bottom()
+}
+
+// sheetGeometry converts between sheet pixels, measured from the top left of
+// A1, and cells, with the real column widths and row heights of the sheet.
+// Sizes are read once and cached, resize through the geometry to keep the
+// cache right.
+type sheetGeometry struct {
+	file       *excelize.File
+	sheet      string
+	colWidths  map[int]float64 // 1-based column number -> pixels
+	rowHeights map[int]float64 // 1-based row number -> pixels
+	colStarts  []float64       // cached left edge of every column, index 0 unused
+	rowStarts  []float64
+}
+
+func newSheetGeometry(file *excelize.File, sheet string) *sheetGeometry {
+	return &sheetGeometry{
+		file:       file,
+		sheet:      sheet,
+		colWidths:  make(map[int]float64),
+		rowHeights: make(map[int]float64),
+	}
+}
+
+// setColWidth resizes the column of the sheet to the pixels (as close as the
+// character units allow).
+func (g *sheetGeometry) setColWidth(col int, pixels float64) error {
+	name, err := excelize.ColumnNumberToName(col)
+	if err != nil {
+		return err
+	}
+	if err := g.file.SetColWidth(g.sheet, name, name, pixelsToCharUnits(pixels)); err != nil {
+		return err
+	}
+	delete(g.colWidths, col)
+	g.colStarts = nil
+	return nil
+}
+
+func (g *sheetGeometry) setRowHeight(row int, pixels float64) error {
+	if err := g.file.SetRowHeight(g.sheet, row, pixelsToPoints(pixels)); err != nil {
+		return err
+	}
+	delete(g.rowHeights, row)
+	g.rowStarts = nil
+	return nil
+}
+
+// colWidth is the width Excel draws the column with, 0 when it is hidden.
+func (g *sheetGeometry) colWidth(col int) float64 {
+	if w, ok := g.colWidths[col]; ok {
+		return w
+	}
+	w := defaultColPixels
+	if name, err := excelize.ColumnNumberToName(col); err == nil {
+		if visible, err := g.file.GetColVisible(g.sheet, name); err == nil && !visible {
+			w = 0
+		} else if chars, err := g.file.GetColWidth(g.sheet, name); err == nil {
+			w = charUnitsToPixels(chars)
+		}
+	}
+	g.colWidths[col] = w
+	return w
+}
+
+// rowHeight is the height Excel draws the row with. Hidden rows are not
+// looked at, excelize reports every row past the last one with data as hidden.
+func (g *sheetGeometry) rowHeight(row int) float64 {
+	if h, ok := g.rowHeights[row]; ok {
+		return h
+	}
+	h := defaultRowPixels
+	if points, err := g.file.GetRowHeight(g.sheet, row); err == nil {
+		h = math.Round(points * pointsToPixels)
+	}
+	g.rowHeights[row] = h
+	return h
+}
+
+// colX is the left edge of the column.
+func (g *sheetGeometry) colX(col int) float64 {
+	g.colStarts = extendStarts(g.colStarts, col, g.colWidth)
+	return g.colStarts[col]
+}
+
+// rowY is the top edge of the row.
+func (g *sheetGeometry) rowY(row int) float64 {
+	g.rowStarts = extendStarts(g.rowStarts, row, g.rowHeight)
+	return g.rowStarts[row]
+}
+
+func extendStarts(starts []float64, n int, size func(int) float64) []float64 {
+	if len(starts) == 0 {
+		starts = []float64{0, 0}
+	}
+	for i := len(starts); i <= n; i++ {
+		starts = append(starts, starts[i-1]+size(i-1))
+	}
+	return starts
+}
+
+// anchor returns the cell holding the point and the offset inside that cell.
+func (g *sheetGeometry) anchor(p point) (col, row int, offsetX, offsetY float64) {
+	col, offsetX = locate(math.Max(p.x, 0), g.colX, g.colWidth)
+	row, offsetY = locate(math.Max(p.y, 0), g.rowY, g.rowHeight)
+	return col, row, offsetX, offsetY
+}
+
+// locate finds the column (or row) that contains the position by walking the
+// sizes from the first one.
+func locate(pos float64, start func(int) float64, size func(int) float64) (int, float64) {
+	n := 1
+	for start(n)+size(n) <= pos {
+		n++
+	}
+	return n, pos - start(n)
+}
+
+// pixelsToCharUnits is the column width to set for a column of that many
+// pixels. Widths are stored in 1/256 of a character.
+func pixelsToCharUnits(pixels float64) float64 {
+	if pixels <= 0 {
+		return 0
+	}
+	return math.Ceil(pixels/maxDigitWidth*256) / 256
+}
+
+// charUnitsToPixels is how wide Excel draws a column of that width
+// (ECMA-376 18.3.1.13, with the 5 pixels of padding already in the width).
+func charUnitsToPixels(chars float64) float64 {
+	return math.Trunc((256*chars + math.Trunc(128/maxDigitWidth)) / 256 * maxDigitWidth)
+}
+
+// pixelsToPoints is the row height to set for a row of that many pixels.
+func pixelsToPoints(pixels float64) float64 {
+	if pixels <= 0 {
+		return 0
+	}
+	return pixels / pointsToPixels
+}
@@ -0,0 +1,356 @@
+package service
+
+import (
+	"fmt"
+	"go_excelize/internal/app/model"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Decision sub numbers, Dx.1 is the false result and Dx.2 the true result.
+// Dx.3 and up are the other outcomes of a decision with more than two.
+const (
+	notationFalseBranch = 1
+	notationTrueBranch  = 2
+)
+
+// notationKey is one parsed key, e.g. "P2.1.1=>IO1" gives prefix "P",
+// path [2 1 1] and target "IO1".
+type notationKey struct {
+	prefix string
+	path   []int
+	target string
+}
+
+// pathKey is the key without the prefix, "2.1.1". Numbers are unique across
+// prefixes, so this is what the flows are indexed by.
+func (k notationKey) pathKey() string {
+	return joinPath(k.path)
+}
+
+func (k notationKey) id() string {
+	return k.prefix + k.pathKey()
+}
+
+// isNode tells a shape key (odd path: 3, 2.1.1) from a decision branch key
+// (even path: 9.1, 2.1.3.2).
+func (k notationKey) isNode() bool {
+	return len(k.path)%2 == 1
+}
+
+func joinPath(path []int) string {
+	parts := make([]string, len(path))
+	for i, n := range path {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}
+
+// parseNotationKey parses "D9.1=>DC2" into its prefix, number path and target.
+func parseNotationKey(raw string) (notationKey, error) {
+	var key notationKey
+	text := strings.ToUpper(strings.TrimSpace(raw))
+	if before, after, found := strings.Cut(text, "=>"); found {
+		text = strings.TrimSpace(before)
+		key.target = strings.TrimSpace(after)
+		if key.target == "" {
+			return key, fmt.Errorf("missing target after '=>'")
+		}
+	}
+
+	i := 0
+	for i < len(text) && text[i] >= 'A' && text[i] <= 'Z' {
+		i++
+	}
+	key.prefix = text[:i]
+	if _, ok := model.ShapeAliases[key.prefix]; !ok {
+		return key, fmt.Errorf("unknown shape key %q, expected one of ON, OF, P, D, IO, MO, DC, PP, DS, PR", key.prefix)
+	}
+	if i == len(text) {
+		return key, fmt.Errorf("missing order number after %q", key.prefix)
+	}
+
+	for _, part := range strings.Split(text[i:], ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 1 {
+			return key, fmt.Errorf("invalid order number %q", part)
+		}
+		key.path = append(key.path, n)
+	}
+	return key, nil
+}
+
+// notationNode is a shape of the table with its position in the tree of flows.
+type notationNode struct {
+	key   notationKey
+	value string
+	field string
+}
+
+// notationFlow is a list of shapes that follow each other, the main flow or
+// the child flow of a decision branch.
+type notationFlow struct {
+	nodes  []*notationNode
+	parent *notationNode // decision owning the branch, nil for the main flow
+}
+
+// ParseKeyNotation turns the key/value table of rancangan.md into the
+// flowchart model. The main flow goes in column 1, the child flow of a
+// decision branch one column to the right of its decision. Errors are
+// model.ValidationErrors pointing at the bad key.
+func ParseKeyNotation(req *model.NotationRequest) (*model.Flowchart, error) {
+	var errs model.ValidationErrors
+
+	nodes := make(map[string]*notationNode)    // key: path "2.1.1"
+	branches := make(map[string]*notationNode) // key: path "9.1"
+	var ordered, orderedBranches []*notationNode
+
+	for _, entry := range req.Flowchart {
+		field := fmt.Sprintf("flowchart[%q]", entry.Key)
+		key, err := parseNotationKey(entry.Key)
+		if err != nil {
+			errs.Add(field, err.Error())
+			continue
+		}
+		item := &notationNode{key: key, value: entry.Value, field: field}
+
+		if !key.isNode() {
+			if key.prefix != "D" {
+				errs.Add(field, fmt.Sprintf("only a decision can have a branch, use D%s", key.pathKey()))
+				continue
+			}
+			if _, dup := branches[key.pathKey()]; dup {
+				errs.Add(field, fmt.Sprintf("branch %s is defined twice", key.pathKey()))
+				continue
+			}
+			branches[key.pathKey()] = item
+			orderedBranches = append(orderedBranches, item)
+			continue
+		}
+
+		if other, dup := nodes[key.pathKey()]; dup {
+			errs.Add(field, fmt.Sprintf("order %s is already used by %s", key.pathKey(), other.key.id()))
+			continue
+		}
+		nodes[key.pathKey()] = item
+		ordered = append(ordered, item)
+	}
+
+	// --- check that every branch and child flow hangs from a decision ---
+	numbers := make(map[string][]int) // branch numbers of every decision path
+	children := make(map[string]bool) // branch paths with a child flow
+	addNumber := func(path []int) {
+		parent := joinPath(path[:len(path)-1])
+		if b := path[len(path)-1]; !slices.Contains(numbers[parent], b) {
+			numbers[parent] = append(numbers[parent], b)
+		}
+	}
+	for _, branch := range orderedBranches {
+		parent := joinPath(branch.key.path[:len(branch.key.path)-1])
+		if decision, ok := nodes[parent]; !ok || decision.key.prefix != "D" {
+			errs.Add(branch.field, fmt.Sprintf("decision D%s is not defined", parent))
+			continue
+		}
+		addNumber(branch.key.path)
+	}
+	for _, node := range ordered {
+		if len(node.key.path) == 1 {
+			continue
+		}
+		parent := joinPath(node.key.path[:len(node.key.path)-2])
+		if decision, ok := nodes[parent]; !ok || decision.key.prefix != "D" {
+			errs.Add(node.field, fmt.Sprintf("child flow needs decision D%s", parent))
+			continue
+		}
+		addNumber(node.key.path[:len(node.key.path)-1])
+		children[joinPath(node.key.path[:len(node.key.path)-1])] = true
+	}
+
+	// --- resolve the manual "=>" connections ---
+	resolveTarget := func(item *notationNode) *notationNode {
+		if item.key.target == "" {
+			return nil
+		}
+		target, err := parseNotationKey(item.key.target)
+		if err != nil || !target.isNode() || target.target != "" {
+			errs.Add(item.field, fmt.Sprintf("invalid target %q", item.key.target))
+			return nil
+		}
+		node, ok := nodes[target.pathKey()]
+		if !ok || node.key.prefix != target.prefix {
+			errs.Add(item.field, fmt.Sprintf("target %s is not defined", target.id()))
+			return nil
+		}
+		return node
+	}
+	targets := make(map[*notationNode]*notationNode)
+	for _, node := range ordered {
+		if target := resolveTarget(node); target != nil {
+			targets[node] = target
+		}
+	}
+	for _, branch := range orderedBranches {
+		if target := resolveTarget(branch); target != nil {
+			targets[branch] = target
+		}
+	}
+
+	// an extra outcome has no default label and no default target
+	for _, branch := range orderedBranches {
+		if branch.key.path[len(branch.key.path)-1] <= notationTrueBranch {
+			continue
+		}
+		if branch.value == "" {
+			errs.Add(branch.field, "the label of a branch after .2 is required")
+		}
+		if branch.key.target == "" && !children[branch.key.pathKey()] {
+			errs.Add(branch.field, fmt.Sprintf("branch leads nowhere, add a child flow (P%s.1) or a => target", branch.key.pathKey()))
+		}
+	}
+	unlabelled := make(map[string]bool)
+	for _, node := range ordered {
+		if len(node.key.path) == 1 || node.key.path[len(node.key.path)-2] <= notationTrueBranch {
+			continue
+		}
+		branchPath := joinPath(node.key.path[:len(node.key.path)-1])
+		if _, ok := branches[branchPath]; !ok && !unlabelled[branchPath] {
+			unlabelled[branchPath] = true
+			errs.Add(node.field, fmt.Sprintf("the child flow needs the label of its branch, add the key D%s", branchPath))
+		}
+	}
+
+	if len(ordered) == 0 {
+		errs.Add("flowchart", "at least one shape is required")
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	// --- group the shapes into flows, sorted by their last order number ---
+	flows := make(map[string]*notationFlow) // key: branch path, "" for the main flow
+	flowOf := make(map[*notationNode]*notationFlow)
+	for _, node := range ordered {
+		branch := joinPath(node.key.path[:len(node.key.path)-1])
+		flow, ok := flows[branch]
+		if !ok {
+			flow = &notationFlow{}
+			if branch != "" {
+				flow.parent = nodes[joinPath(node.key.path[:len(node.key.path)-2])]
+			}
+			flows[branch] = flow
+		}
+		flow.nodes = append(flow.nodes, node)
+		flowOf[node] = flow
+	}
+	for _, flow := range flows {
+		sort.SliceStable(flow.nodes, func(i, j int) bool {
+			return flow.nodes[i].key.path[len(flow.nodes[i].key.path)-1] < flow.nodes[j].key.path[len(flow.nodes[j].key.path)-1]
+		})
+	}
+
+	// next returns the shape that comes after node: its sibling, or when the
+	// child flow ends, the shape after the decision it branched from.
+	var next func(node *notationNode) *notationNode
+	next = func(node *notationNode) *notationNode {
+		flow := flowOf[node]
+		for i, n := range flow.nodes {
+			if n == node && i+1 < len(flow.nodes) {
+				return flow.nodes[i+1]
+			}
+		}
+		if flow.parent == nil {
+			return nil
+		}
+		return next(flow.parent)
+	}
+
+	// the start and end terminators are added with the others, after validation
+	flowchart := &model.Flowchart{Layout: req.Layout, WithTerminator: req.WithTerminator}
+
+	// --- walk the flows depth first so a child flow sits right after its decision ---
+	var walk func(flow *notationFlow, column int)
+	walk = func(flow *notationFlow, column int) {
+		for _, node := range flow.nodes {
+			flowchart.Nodes = append(flowchart.Nodes, model.Node{
+				ID:     node.key.id(),
+				Type:   model.ShapeAliases[node.key.prefix],
+				Label:  node.value,
+				Column: column,
+			})
+
+			if node.key.prefix != "D" {
+				target, ok := targets[node]
+				if !ok {
+					target = next(node)
+				}
+				if target != nil {
+					flowchart.Edges = append(flowchart.Edges, model.Edge{Source: node.key.id(), Target: target.key.id(), Branch: model.BranchNext})
+				}
+				continue
+			}
+
+			// true and false first, then the other outcomes in their order
+			used := slices.Sorted(slices.Values(numbers[node.key.pathKey()]))
+			order := []int{notationTrueBranch, notationFalseBranch}
+			for _, b := range used {
+				if b > notationTrueBranch {
+					order = append(order, b)
+				}
+			}
+			seen := make(map[*notationNode]int) // targets of the branches so far
+			for _, b := range order {
+				branchPath := node.key.pathKey() + "." + strconv.Itoa(b)
+				branch := branches[branchPath]
+				child := flows[branchPath]
+
+				var target *notationNode
+				switch {
+				case branch != nil && targets[branch] != nil:
+					target = targets[branch]
+				case child != nil:
+					target = child.nodes[0]
+				case b == notationTrueBranch:
+					target = next(node) // "Ya" continues with the main flow by default
+				}
+				if target == nil {
+					continue
+				}
+				// two exits to the same step would be a duplicate edge
+				if first, ok := seen[target]; ok {
+					field := node.field
+					if branch != nil {
+						field = branch.field
+					}
+					errs.Add(field, fmt.Sprintf("leads to %s like branch D%s.%d, the branches of a decision go to different steps", target.key.id(), node.key.pathKey(), first))
+					continue
+				}
+				seen[target] = b
+
+				edge := model.Edge{Source: node.key.id(), Target: target.key.id(), Branch: model.BranchNext}
+				switch b {
+				case notationTrueBranch:
+					edge.Branch = model.BranchTrue
+				case notationFalseBranch:
+					edge.Branch = model.BranchFalse
+				}
+				if branch != nil {
+					edge.Label = branch.value
+				}
+				flowchart.Edges = append(flowchart.Edges, edge)
+			}
+
+			for _, b := range used {
+				if child, ok := flows[node.key.pathKey()+"."+strconv.Itoa(b)]; ok {
+					walk(child, column+1)
+				}
+			}
+		}
+	}
+	walk(flows[""], 1)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return flowchart, nil
+}
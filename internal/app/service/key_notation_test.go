@@ -0,0 +1,138 @@
+package service
+
+import (
+	"go_excelize/internal/app/model"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestParseKeyNotation(t *testing.T) {
+	tests := []struct {
+		name   string
+		table  model.NotationTable
+		nodes  []string // id:column
+		edges  []string // source->target branch label
+		fields []string // of the errors, instead of nodes and edges
+	}{
+		{
+			name:  "main flow",
+			table: model.NotationTable{{Key: "IO1", Value: "Isi"}, {Key: "P2", Value: "Simpan"}},
+			nodes: []string{"IO1:1", "P2:1"},
+			edges: []string{"IO1->P2 next "},
+		},
+		{
+			name: "false branch back to a step",
+			table: model.NotationTable{
+				{Key: "IO1", Value: "Isi"}, {Key: "D2", Value: "Lengkap?"},
+				{Key: "D2.1=>IO1", Value: "Belum"}, {Key: "D2.2", Value: "Ya"}, {Key: "P3", Value: "Simpan"},
+			},
+			nodes: []string{"IO1:1", "D2:1", "P3:1"},
+			edges: []string{"IO1->D2 next ", "D2->P3 true Ya", "D2->IO1 false Belum"},
+		},
+		{
+			name: "child flow joins back",
+			table: model.NotationTable{
+				{Key: "D1", Value: "Lulus?"}, {Key: "P1.1.1", Value: "Remedial"}, {Key: "P1.1.2", Value: "Ujian ulang"}, {Key: "P2", Value: "Wisuda"},
+			},
+			nodes: []string{"D1:1", "P1.1.1:2", "P1.1.2:2", "P2:1"},
+			edges: []string{"D1->P2 true ", "D1->P1.1.1 false ", "P1.1.1->P1.1.2 next ", "P1.1.2->P2 next "},
+		},
+		{
+			name: "keys in any order",
+			table: model.NotationTable{
+				{Key: "P3", Value: "c"}, {Key: "P1", Value: "a"}, {Key: "P2", Value: "b"},
+			},
+			nodes: []string{"P1:1", "P2:1", "P3:1"},
+			edges: []string{"P1->P2 next ", "P2->P3 next "},
+		},
+		{
+			name: "more than two branches",
+			table: model.NotationTable{
+				{Key: "IO1", Value: "Pesanan"}, {Key: "D2", Value: "Status?"}, {Key: "D2.1=>IO1", Value: "Batal"}, {Key: "D2.2", Value: "Baru"},
+				{Key: "D2.3", Value: "Retur"}, {Key: "P2.3.1", Value: "Cek barang"}, {Key: "D2.4=>P4", Value: "Lunas"},
+				{Key: "P3", Value: "Kirim"}, {Key: "P4", Value: "Selesai"},
+			},
+			nodes: []string{"IO1:1", "D2:1", "P2.3.1:2", "P3:1", "P4:1"},
+			edges: []string{"IO1->D2 next ", "D2->P3 true Baru", "D2->IO1 false Batal", "D2->P2.3.1 next Retur", "D2->P4 next Lunas", "P2.3.1->P3 next ", "P3->P4 next "},
+		},
+		{
+			name:   "unknown prefix",
+			table:  model.NotationTable{{Key: "X1", Value: "?"}},
+			fields: []string{`flowchart["X1"]`, "flowchart"},
+		},
+		{
+			name:   "child flow without decision",
+			table:  model.NotationTable{{Key: "P1", Value: "a"}, {Key: "P1.1.1", Value: "b"}},
+			fields: []string{`flowchart["P1.1.1"]`},
+		},
+		{
+			name:   "branch of a step",
+			table:  model.NotationTable{{Key: "P1", Value: "a"}, {Key: "P1.1", Value: "b"}},
+			fields: []string{`flowchart["P1.1"]`},
+		},
+		{
+			name:   "unknown target",
+			table:  model.NotationTable{{Key: "D1", Value: "a"}, {Key: "D1.1=>P9", Value: "b"}},
+			fields: []string{`flowchart["D1.1=>P9"]`},
+		},
+		{
+			name: "extra branch leads nowhere",
+			table: model.NotationTable{
+				{Key: "D1", Value: "a"}, {Key: "D1.3", Value: "Retur"}, {Key: "P2", Value: "b"},
+			},
+			fields: []string{`flowchart["D1.3"]`},
+		},
+		{
+			// Diagnose would report the duplicate edge D1 -> P2
+			name: "branch to the target of another",
+			table: model.NotationTable{
+				{Key: "D1", Value: "Status?"}, {Key: "D1.1=>P2", Value: "Batal"}, {Key: "D1.2", Value: "Baru"}, {Key: "P2", Value: "Selesai"},
+			},
+			fields: []string{`flowchart["D1.1=>P2"]`},
+		},
+		{
+			name: "extra branch without label",
+			table: model.NotationTable{
+				{Key: "D1", Value: "a"}, {Key: "P1.3.1", Value: "b"}, {Key: "P1.3.2", Value: "c"},
+			},
+			fields: []string{`flowchart["P1.3.1"]`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flow, err := ParseKeyNotation(&model.NotationRequest{Flowchart: tt.table, WithTerminator: true})
+			fields, ok := model.ErrorFields(err)
+			if !ok {
+				t.Fatalf("ParseKeyNotation() error = %v, want ValidationErrors", err)
+			}
+			if !slices.Equal(fields, tt.fields) {
+				t.Fatalf("ParseKeyNotation() error fields = %q, want %q", fields, tt.fields)
+			}
+			if err != nil {
+				return
+			}
+			var nodes, edges []string
+			for _, n := range flow.Nodes {
+				nodes = append(nodes, n.ID+":"+strconv.Itoa(n.Column))
+			}
+			for _, e := range flow.Edges {
+				edges = append(edges, e.Source+"->"+e.Target+" "+e.Branch+" "+e.Label)
+			}
+			if !slices.Equal(nodes, tt.nodes) {
+				t.Errorf("nodes = %q, want %q", nodes, tt.nodes)
+			}
+			if !slices.Equal(edges, tt.edges) {
+				t.Errorf("edges = %q, want %q", edges, tt.edges)
+			}
+			// the chart passes the graph rules of POST /excel/validate too
+			flow.ApplyDefaults()
+			flow.AddTerminators()
+			for _, d := range flow.Diagnose() {
+				if d.Severity == model.SeverityError {
+					t.Errorf("Diagnose() %s %s: %s", d.Rule, d.Node, d.Message)
+				}
+			}
+		})
+	}
+}
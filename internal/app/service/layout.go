@@ -0,0 +1,478 @@
+package service
+
+import (
+	"go_excelize/internal/app/model"
+	"math"
+	"slices"
+	"sort"
+)
+
+// gridPos is where a node sits, in columns and rows from the start cell.
+type gridPos struct {
+	col int
+	row int
+}
+
+// computeLayout places every node of the flowchart on the grid, with the
+// manual `column` placement or the automatic layered one.
+func computeLayout(flow *model.Flowchart) map[string]gridPos {
+	if flow.Layout.Mode == model.LayoutManual {
+		return manualLayout(flow)
+	}
+	return layeredLayout(flow)
+}
+
+// manualLayout is the original placement: the column comes from the node,
+// the row goes down by `gap` while the column stays the same, and a node in
+// another column starts at the row after the previous node.
+func manualLayout(flow *model.Flowchart) map[string]gridPos {
+	positions := make(map[string]gridPos, len(flow.Nodes))
+	colRows := make(map[int]int)
+	var prevCol, prevRow int
+	for i, node := range flow.Nodes {
+		col := node.Column - 1
+		var row int
+		if i == 0 {
+			row = 0
+		} else if col == prevCol {
+			row = colRows[col]
+		} else {
+			row = prevRow
+		}
+		positions[node.ID] = gridPos{col: col, row: row}
+
+		colRows[col] = row + flow.Layout.Gap
+		prevCol = col
+		prevRow = colRows[col]
+	}
+	return positions
+}
+
+// --- Layered (Sugiyama style) layout ---
+
+// layerGraph is the flowchart as an acyclic graph, split in layers, with
+// dummy vertices where an edge crosses more than one layer.
+type layerGraph struct {
+	count   int     // real nodes are 0..count-1, the rest are dummies
+	out     [][]int // forward edges after the cycles are broken
+	in      [][]int
+	layer   []int
+	layers  [][]int // vertices of every layer, left to right
+	primary []int   // the child kept straight below a vertex, -1 if none
+	pinned  []int   // manual column of a real node, -1 if free
+	fan     []bool  // a real node with its children side by side, a switch or a fork
+}
+
+// layeredLayout computes the rows and columns from the edges alone: cycles
+// are broken, every node gets the layer of its longest path from a start,
+// layers are ordered to reduce crossings and the true (or next) child of a
+// node is kept in the column of its parent. The children of a node with more
+// than two fan out to both sides of the middle one, which goes straight
+// below. A node with a column set keeps it, unless an earlier node of its
+// layer has the same one.
+func layeredLayout(flow *model.Flowchart) map[string]gridPos {
+	g := newLayerGraph(flow)
+	g.assignLayers()
+	g.addDummies()
+	g.orderLayers()
+	cols := g.assignColumns()
+
+	positions := make(map[string]gridPos, len(flow.Nodes))
+	for i, node := range flow.Nodes {
+		positions[node.ID] = gridPos{col: cols[i], row: g.layer[i] * flow.Layout.Gap}
+	}
+	return positions
+}
+
+func newLayerGraph(flow *model.Flowchart) *layerGraph {
+	index := flow.NodeIndex()
+	n := len(flow.Nodes)
+	g := &layerGraph{
+		count:   n,
+		out:     make([][]int, n),
+		in:      make([][]int, n),
+		primary: make([]int, n),
+		pinned:  make([]int, n),
+		fan:     make([]bool, n),
+	}
+	for i, node := range flow.Nodes {
+		g.primary[i] = -1
+		g.pinned[i] = node.Column - 1
+	}
+
+	// true and next edges first, so the depth first search follows the main
+	// path and the false branches are the ones that become back edges
+	type link struct{ from, to int }
+	var links []link
+	for _, rank := range []int{0, 1} {
+		for _, edge := range flow.Edges {
+			if (edge.Branch == model.BranchFalse) != (rank == 1) {
+				continue
+			}
+			from, ok1 := index[edge.Source]
+			to, ok2 := index[edge.Target]
+			if !ok1 || !ok2 || from == to {
+				continue
+			}
+			links = append(links, link{from, to})
+			if rank == 0 && g.primary[from] == -1 {
+				g.primary[from] = to
+			}
+		}
+	}
+	adjacency := make([][]int, n)
+	for _, l := range links {
+		adjacency[l.from] = append(adjacency[l.from], l.to)
+	}
+	// a node with more than two children keeps the middle one below it, the
+	// branches of a fork go side by side whatever their number
+	for v, children := range adjacency {
+		if len(children) > 2 || len(children) > 1 && flow.Nodes[v].Type == model.NodeFork {
+			g.fan[v] = true
+			g.primary[v] = children[(len(children)-1)/2]
+		}
+	}
+
+	// --- break the cycles: a back edge found by the DFS is reversed ---
+	const (
+		unvisited = iota
+		onStack
+		done
+	)
+	state := make([]int, n)
+	seen := make(map[[2]int]bool)
+	addEdge := func(from, to int) {
+		if seen[[2]int{from, to}] {
+			return
+		}
+		seen[[2]int{from, to}] = true
+		g.out[from] = append(g.out[from], to)
+		g.in[to] = append(g.in[to], from)
+	}
+	var visit func(v int)
+	visit = func(v int) {
+		state[v] = onStack
+		for _, w := range adjacency[v] {
+			switch state[w] {
+			case unvisited:
+				addEdge(v, w)
+				visit(w)
+			case onStack:
+				addEdge(w, v)
+				if g.primary[v] == w {
+					g.primary[v] = -1
+				}
+			default:
+				addEdge(v, w)
+			}
+		}
+		state[v] = done
+	}
+	// start from the nodes nobody points at, then whatever is left (cycles)
+	hasIncoming := make([]bool, n)
+	for _, l := range links {
+		hasIncoming[l.to] = true
+	}
+	for v := 0; v < n; v++ {
+		if !hasIncoming[v] && state[v] == unvisited {
+			visit(v)
+		}
+	}
+	for v := 0; v < n; v++ {
+		if state[v] == unvisited {
+			visit(v)
+		}
+	}
+	return g
+}
+
+// assignLayers gives every vertex the length of its longest path from a
+// start, in topological order.
+func (g *layerGraph) assignLayers() {
+	g.layer = make([]int, g.count)
+	indegree := make([]int, g.count)
+	for v := range g.in {
+		indegree[v] = len(g.in[v])
+	}
+	var queue []int
+	for v := 0; v < g.count; v++ {
+		if indegree[v] == 0 {
+			queue = append(queue, v)
+		}
+	}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		for _, w := range g.out[v] {
+			if g.layer[v]+1 > g.layer[w] {
+				g.layer[w] = g.layer[v] + 1
+			}
+			indegree[w]--
+			if indegree[w] == 0 {
+				queue = append(queue, w)
+			}
+		}
+	}
+}
+
+// addDummies splits every edge longer than one layer with a dummy vertex per
+// crossed layer, so a long edge gets its own slot in the crossed layers. The
+// dummies of a chain are primary children of each other to keep it straight.
+func (g *layerGraph) addDummies() {
+	for v := 0; v < g.count; v++ {
+		for i, w := range g.out[v] {
+			if g.layer[w]-g.layer[v] <= 1 {
+				continue
+			}
+			wasPrimary := g.primary[v] == w
+			prev := v
+			for l := g.layer[v] + 1; l < g.layer[w]; l++ {
+				d := len(g.layer)
+				g.layer = append(g.layer, l)
+				g.out = append(g.out, nil)
+				g.in = append(g.in, []int{prev})
+				g.primary = append(g.primary, -1)
+				if prev == v {
+					g.out[v][i] = d
+					if wasPrimary {
+						g.primary[v] = d
+					}
+				} else {
+					g.out[prev] = []int{d}
+					g.primary[prev] = d
+				}
+				prev = d
+			}
+			g.out[prev] = []int{w}
+			g.primary[prev] = w
+			for j, u := range g.in[w] {
+				if u == v {
+					g.in[w][j] = prev
+				}
+			}
+		}
+	}
+}
+
+// orderLayers sorts every layer by the barycenter of the neighbours, sweeping
+// down and up a few times and keeping the order with the fewest crossings.
+func (g *layerGraph) orderLayers() {
+	depth := 0
+	for _, l := range g.layer {
+		if l+1 > depth {
+			depth = l + 1
+		}
+	}
+
+	// first order: depth first from the starts, primary child first, so a
+	// false branch lands on the right of the true branch
+	g.layers = make([][]int, depth)
+	visited := make([]bool, len(g.layer))
+	var visit func(v int)
+	visit = func(v int) {
+		if visited[v] {
+			return
+		}
+		visited[v] = true
+		g.layers[g.layer[v]] = append(g.layers[g.layer[v]], v)
+		// the children of a fan stay in the order of the edges
+		if p := g.primary[v]; p != -1 && !(v < g.count && g.fan[v]) {
+			visit(p)
+		}
+		for _, w := range g.out[v] {
+			visit(w)
+		}
+	}
+	for v := 0; v < g.count; v++ {
+		if len(g.in[v]) == 0 {
+			visit(v)
+		}
+	}
+	for v := range g.layer {
+		visit(v)
+	}
+
+	best := g.copyLayers()
+	bestCrossings := g.crossings()
+	for sweep := 0; sweep < 8 && bestCrossings > 0; sweep++ {
+		if sweep%2 == 0 {
+			for l := 1; l < depth; l++ {
+				g.sortByBarycenter(l, l-1, g.in)
+			}
+		} else {
+			for l := depth - 2; l >= 0; l-- {
+				g.sortByBarycenter(l, l+1, g.out)
+			}
+		}
+		if c := g.crossings(); c < bestCrossings {
+			best, bestCrossings = g.copyLayers(), c
+		}
+	}
+	g.layers = best
+}
+
+func (g *layerGraph) copyLayers() [][]int {
+	layers := make([][]int, len(g.layers))
+	for i, layer := range g.layers {
+		layers[i] = append([]int(nil), layer...)
+	}
+	return layers
+}
+
+// sortByBarycenter orders layer l by the average position of the neighbours
+// in the fixed layer. A vertex without neighbours keeps its position.
+func (g *layerGraph) sortByBarycenter(l, fixed int, neighbours [][]int) {
+	position := make(map[int]int, len(g.layers[fixed]))
+	for i, v := range g.layers[fixed] {
+		position[v] = i
+	}
+	bary := make(map[int]float64, len(g.layers[l]))
+	for i, v := range g.layers[l] {
+		sum, count := 0.0, 0
+		for _, w := range neighbours[v] {
+			if p, ok := position[w]; ok {
+				sum += float64(p)
+				count++
+			}
+		}
+		if count == 0 {
+			bary[v] = float64(i)
+		} else {
+			bary[v] = sum / float64(count)
+		}
+	}
+	sort.SliceStable(g.layers[l], func(i, j int) bool {
+		return bary[g.layers[l][i]] < bary[g.layers[l][j]]
+	})
+}
+
+// crossings counts the edge crossings between every pair of adjacent layers.
+func (g *layerGraph) crossings() int {
+	total := 0
+	position := make([]int, len(g.layer))
+	for _, layer := range g.layers {
+		for i, v := range layer {
+			position[v] = i
+		}
+	}
+	for _, layer := range g.layers {
+		var edges [][2]int
+		for _, v := range layer {
+			for _, w := range g.out[v] {
+				edges = append(edges, [2]int{position[v], position[w]})
+			}
+		}
+		for i := range edges {
+			for j := i + 1; j < len(edges); j++ {
+				a, b := edges[i], edges[j]
+				if (a[0]-b[0])*(a[1]-b[1]) < 0 {
+					total++
+				}
+			}
+		}
+	}
+	return total
+}
+
+// assignColumns walks the layers top down. A vertex wants the column of the
+// parent that has it as primary child (so the true branch goes straight
+// down), else its place in the fan of its parent, else the middle of its
+// parents, and is pushed right when the slot is taken by its left
+// neighbour. Pinned nodes keep their manual column, a later pin of a column
+// already taken in the layer moves to the nearest free one. Without pins a
+// fan may reach left of the first column, the columns are shifted back at
+// the end.
+func (g *layerGraph) assignColumns() []int {
+	cols := make([]int, len(g.layer))
+	straightParent := make([]int, len(g.layer))
+	for v := range straightParent {
+		straightParent[v] = -1
+	}
+	for v, p := range g.primary {
+		if p != -1 && straightParent[p] == -1 {
+			straightParent[p] = v
+		}
+	}
+	fanParent := make([]int, len(g.layer))
+	fanOffset := make([]int, len(g.layer))
+	for v := range fanParent {
+		fanParent[v] = -1
+	}
+	for v := 0; v < g.count; v++ {
+		if !g.fan[v] {
+			continue
+		}
+		middle := (len(g.out[v]) - 1) / 2
+		for i, w := range g.out[v] {
+			if w == g.primary[v] {
+				middle = i
+			}
+		}
+		for i, w := range g.out[v] {
+			if fanParent[w] == -1 && g.layer[w] == g.layer[v]+1 {
+				fanParent[w], fanOffset[w] = v, i-middle
+			}
+		}
+	}
+	first := 0
+	if !slices.ContainsFunc(g.pinned, func(col int) bool { return col >= 0 }) {
+		first = math.MinInt / 2
+	}
+
+	for _, layer := range g.layers {
+		taken := make(map[int]bool)
+		for _, v := range layer {
+			if v < g.count && g.pinned[v] >= 0 {
+				cols[v] = nearestFree(taken, g.pinned[v])
+				taken[cols[v]] = true
+			}
+		}
+		next := first
+		for _, v := range layer {
+			if v < g.count && g.pinned[v] >= 0 {
+				continue
+			}
+			want := 0
+			switch {
+			case straightParent[v] != -1:
+				want = cols[straightParent[v]]
+			case fanParent[v] != -1:
+				want = cols[fanParent[v]] + fanOffset[v]
+			case len(g.in[v]) > 0:
+				parents := make([]int, 0, len(g.in[v]))
+				for _, u := range g.in[v] {
+					parents = append(parents, cols[u])
+				}
+				sort.Ints(parents)
+				want = parents[(len(parents)-1)/2]
+			}
+			col := max(want, next)
+			for taken[col] {
+				col++
+			}
+			cols[v] = col
+			taken[col] = true
+			next = col + 1
+		}
+	}
+
+	if shift := slices.Min(cols[:g.count]); shift < 0 {
+		for v := range cols {
+			cols[v] -= shift
+		}
+	}
+	return cols
+}
+
+// nearestFree is the free column closest to col, to the right on a tie and
+// never left of the first one.
+func nearestFree(taken map[int]bool, col int) int {
+	for d := 0; ; d++ {
+		if !taken[col+d] {
+			return col + d
+		}
+		if col-d >= 0 && !taken[col-d] {
+			return col - d
+		}
+	}
+}
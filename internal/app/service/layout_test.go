@@ -0,0 +1,31 @@
+package service
+
+import (
+	"go_excelize/internal/app/model"
+	"testing"
+)
+
+func TestLayeredLayoutPins(t *testing.T) {
+	flow := &model.Flowchart{
+		Nodes: []model.Node{
+			{ID: "a", Type: "P", Label: "a"},
+			{ID: "d", Type: "D", Label: "d?"},
+			{ID: "b", Type: "P", Label: "b", Column: 1},
+			{ID: "c", Type: "P", Label: "c", Column: 1},
+		},
+		Edges: []model.Edge{
+			{Source: "a", Target: "d"},
+			{Source: "d", Target: "b", Branch: model.BranchTrue},
+			{Source: "d", Target: "c", Branch: model.BranchFalse},
+		},
+	}
+	flow.ApplyDefaults()
+	positions := layeredLayout(flow)
+	// the first pin keeps its column, the second moves next to it
+	if got := positions["b"]; got.col != 0 {
+		t.Errorf("b = %+v, want column 0", got)
+	}
+	if got := positions["c"]; got.col != 1 || got.row != positions["b"].row {
+		t.Errorf("c = %+v, want column 1 in the row of b %+v", got, positions["b"])
+	}
+}
@@ -0,0 +1,560 @@
+package service
+
+import (
+	"fmt"
+	"go_excelize/internal/app/model"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// mermaidBrackets are the node shapes of the classic syntax, A[text],
+// B{text} and so on, with the preset each one is drawn as. The longest
+// opening is tried first, "[/" can close with "/]" or "\]".
+var mermaidBrackets = []struct{ open, close, nodeType string }{
+	{"(((", ")))", "flowChartConnector"},
+	{"([", "])", "flowChartTerminator"},
+	{"[[", "]]", "flowChartPredefinedProcess"},
+	{"[(", ")]", "flowChartMagneticDisk"},
+	{"((", "))", "flowChartConnector"},
+	{"{{", "}}", "flowChartPreparation"},
+	{"[/", "/]", "flowChartInputOutput"},
+	{"[/", `\]`, "flowChartManualOperation"},
+	{`[\`, `\]`, "flowChartInputOutput"},
+	{`[\`, "/]", "flowChartManualOperation"},
+	{"[", "]", "flowChartProcess"},
+	{"(", ")", "flowChartAlternateProcess"},
+	{"{", "}", "flowChartDecision"},
+	{">", "]", "flowChartOffpageConnector"},
+}
+
+// mermaidShapes maps the shape names of the A@{ shape: doc } syntax to the
+// preset they are drawn as.
+var mermaidShapes = map[string]string{
+	"rect": "flowChartProcess", "proc": "flowChartProcess", "process": "flowChartProcess", "rectangle": "flowChartProcess",
+	"rounded": "flowChartAlternateProcess", "event": "flowChartAlternateProcess",
+	"stadium": "flowChartTerminator", "terminal": "flowChartTerminator", "pill": "flowChartTerminator",
+	"subproc": "flowChartPredefinedProcess", "subprocess": "flowChartPredefinedProcess", "subroutine": "flowChartPredefinedProcess",
+	"fr-rect": "flowChartPredefinedProcess", "framed-rectangle": "flowChartPredefinedProcess",
+	"cyl": "flowChartMagneticDisk", "cylinder": "flowChartMagneticDisk", "database": "flowChartMagneticDisk", "db": "flowChartMagneticDisk",
+	"circle": "flowChartConnector", "circ": "flowChartConnector", "sm-circ": "flowChartConnector", "small-circle": "flowChartConnector",
+	"dbl-circ": "flowChartConnector", "double-circle": "flowChartConnector",
+	"diam": "flowChartDecision", "diamond": "flowChartDecision", "decision": "flowChartDecision", "question": "flowChartDecision",
+	"hex": "flowChartPreparation", "hexagon": "flowChartPreparation", "prepare": "flowChartPreparation",
+	"lean-r": "flowChartInputOutput", "lean-right": "flowChartInputOutput", "in-out": "flowChartInputOutput",
+	"lean-l": "flowChartInputOutput", "lean-left": "flowChartInputOutput", "out-in": "flowChartInputOutput",
+	"trap-t": "flowChartManualOperation", "trapezoid-top": "flowChartManualOperation", "inv-trapezoid": "flowChartManualOperation", "manual": "flowChartManualOperation",
+	"trap-b": "flowChartManualOperation", "trapezoid-bottom": "flowChartManualOperation", "trapezoid": "flowChartManualOperation", "priority": "flowChartManualOperation",
+	"doc": "flowChartDocument", "document": "flowChartDocument",
+	"sl-rect": "flowChartManualInput", "sloped-rectangle": "flowChartManualInput", "manual-input": "flowChartManualInput",
+	"curv-trap": "flowChartDisplay", "curved-trapezoid": "flowChartDisplay", "display": "flowChartDisplay",
+	"delay": "flowChartDelay", "half-rounded-rectangle": "flowChartDelay",
+	"win-pane": "flowChartInternalStorage", "window-pane": "flowChartInternalStorage", "internal-storage": "flowChartInternalStorage",
+	"hourglass": "flowChartCollate", "collate": "flowChartCollate",
+	"tri": "flowChartExtract", "triangle": "flowChartExtract", "extract": "flowChartExtract",
+	"flip-tri": "flowChartMerge", "flipped-triangle": "flowChartMerge", "manual-file": "flowChartMerge",
+	"cross-circ": "flowChartSummingJunction", "crossed-circle": "flowChartSummingJunction", "summary": "flowChartSummingJunction",
+	"notch-rect": "flowChartPunchedCard", "notched-rectangle": "flowChartPunchedCard", "card": "flowChartPunchedCard",
+	"odd": "flowChartOffpageConnector", "asymmetric": "flowChartOffpageConnector",
+	"fork": model.NodeFork, "join": model.NodeJoin,
+}
+
+// mermaidDirections are the directions of the flowchart header, TD is TB.
+var mermaidDirections = map[string]string{
+	"TB": model.DirectionTB, "TD": model.DirectionTB, "BT": model.DirectionBT,
+	"LR": model.DirectionLR, "RL": model.DirectionRL,
+}
+
+// Links between nodes: A --> B, A -->|Yes| B, A -- Yes --> B, dotted
+// (-.->) and thick (==>), with or without an arrow head.
+var (
+	mermaidLink     = regexp.MustCompile(`^<?(?:-{2,}>|-{3,}|={2,}>|={3,}|-\.+->|-\.+-|--[ox](?:\s|$)|==[ox](?:\s|$))(?:\s*\|([^|]*)\|)?`)
+	mermaidTextLink = regexp.MustCompile(`^<?(?:--|==|-\.)\s*(.*?)\s*(?:-{2,}>|-{3,}|={2,}>|={3,}|\.+->|\.+-)`)
+	mermaidBreak    = regexp.MustCompile(`(?i)<br\s*/?>`)
+	mermaidEntity   = regexp.MustCompile(`#(quot|amp|lt|gt|\d+);`)
+)
+
+// Statements that only style the chart, they are skipped.
+var mermaidIgnored = []string{"classDef", "class", "style", "linkStyle", "click", "direction", "accTitle", "accDescr"}
+
+// mermaidNode is a node as written in a statement, its shape and label only
+// when the statement gives them. A[""] gives an empty label.
+type mermaidNode struct {
+	id, nodeType, label string
+	hasLabel            bool
+}
+
+// mermaidParser keeps the nodes in the order they first show up and the
+// subgraphs open at the current line.
+type mermaidParser struct {
+	flow      *model.Flowchart
+	nodes     map[string]int // id -> index in flow.Nodes
+	labelled  map[string]bool
+	open      []string            // titles of the open subgraphs, outermost first
+	inside    map[string][]string // node id -> the subgraphs it was first put in
+	subgraphs [][2]string         // id and line of every subgraph
+	outer     []string            // titles of the outermost subgraphs
+	direction string
+	header    bool
+	errs      model.ValidationErrors
+}
+
+// ParseMermaid turns a Mermaid flowchart (flowchart TD, graph LR...) into
+// the flowchart model: the node shapes, the links with their labels, the
+// direction and the subgraphs. When every node is in a subgraph the
+// outermost ones become swimlanes, otherwise the subgraphs become groups.
+// A labelled link leaving a decision with a yes or no label is its true or
+// false branch. Errors are model.ValidationErrors pointing at the line.
+func ParseMermaid(req *model.MermaidRequest) (*model.Flowchart, error) {
+	p := &mermaidParser{
+		flow:     &model.Flowchart{Layout: req.Layout, WithTerminator: req.WithTerminator},
+		nodes:    map[string]int{},
+		labelled: map[string]bool{},
+		inside:   map[string][]string{},
+	}
+
+	lines := strings.Split(strings.ReplaceAll(req.Mermaid, "\r\n", "\n"), "\n")
+	first := 0
+	for first < len(lines) && strings.TrimSpace(lines[first]) == "" {
+		first++
+	}
+	// a front matter block on top can give the title
+	if first < len(lines) && strings.TrimSpace(lines[first]) == "---" {
+		for i := first + 1; i < len(lines); i++ {
+			line := strings.TrimSpace(lines[i])
+			if line == "---" {
+				first = i + 1
+				break
+			}
+			if title, ok := strings.CutPrefix(line, "title:"); ok {
+				title = strings.TrimSpace(title)
+				if unquoted, err := strconv.Unquote(title); err == nil {
+					title = unquoted
+				}
+				p.flow.Metadata = map[string]string{"title": title}
+			}
+		}
+	}
+
+	for n := first; n < len(lines); n++ {
+		field := fmt.Sprintf("mermaid line %d", n+1)
+		line := lines[n]
+		if i := strings.Index(line, "%%"); i >= 0 {
+			line = line[:i]
+		}
+		for _, statement := range splitStatements(line) {
+			if err := p.statement(statement, field); err != nil {
+				p.errs.Add(field, err.Error())
+			}
+			// the rest means nothing without the header
+			if !p.header && len(p.errs) > 0 {
+				return nil, p.errs
+			}
+		}
+	}
+	if !p.header {
+		return nil, model.ValidationErrors{{Field: "mermaid", Message: `must start with a "flowchart" or "graph" header`}}
+	}
+	if len(p.open) > 0 {
+		p.errs.Add("mermaid", fmt.Sprintf("subgraph %q is not closed with end", p.open[len(p.open)-1]))
+	}
+	for _, sub := range p.subgraphs {
+		if _, ok := p.nodes[sub[0]]; ok {
+			p.errs.Add(sub[1], fmt.Sprintf("subgraph %q is linked like a node, link the nodes inside it", sub[0]))
+		}
+	}
+	if len(p.flow.Nodes) == 0 && len(p.errs) == 0 {
+		p.errs.Add("mermaid", "at least one node is required")
+	}
+	if len(p.errs) > 0 {
+		return nil, p.errs
+	}
+
+	if p.flow.Layout.Direction == "" {
+		p.flow.Layout.Direction = p.direction
+	}
+	p.placeSubgraphs()
+	p.setBranches()
+	return p.flow, nil
+}
+
+// statement reads one statement: the header, a subgraph line or a chain of
+// nodes and links like A & B --> C -- No --> D.
+func (p *mermaidParser) statement(s, field string) error {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	keyword, rest, _ := strings.Cut(s, " ")
+	if !p.header {
+		switch strings.TrimSuffix(keyword, "-elk") {
+		case "flowchart", "graph":
+		default:
+			return fmt.Errorf("only flowchart diagrams are supported, got %q", keyword)
+		}
+		p.header = true
+		p.direction = model.DirectionTB
+		if rest = strings.TrimSpace(rest); rest != "" {
+			direction, ok := mermaidDirections[strings.ToUpper(rest)]
+			if !ok {
+				return fmt.Errorf("unknown direction %q, use TB, TD, BT, LR or RL", rest)
+			}
+			p.direction = direction
+		}
+		return nil
+	}
+
+	switch {
+	case keyword == "subgraph":
+		id, title := parseSubgraph(strings.TrimSpace(rest))
+		if id == "" {
+			return fmt.Errorf("subgraph needs an id or a title")
+		}
+		p.subgraphs = append(p.subgraphs, [2]string{id, field})
+		if len(p.open) == 0 && !slices.Contains(p.outer, title) {
+			p.outer = append(p.outer, title)
+		}
+		p.open = append(p.open, title)
+		return nil
+	case s == "end":
+		if len(p.open) == 0 {
+			return fmt.Errorf("end without a subgraph")
+		}
+		p.open = p.open[:len(p.open)-1]
+		return nil
+	}
+	for _, ignored := range mermaidIgnored {
+		if keyword == ignored || strings.HasPrefix(keyword, ignored+":") {
+			return nil
+		}
+	}
+
+	from, rest, err := p.nodeGroup(s)
+	if err != nil {
+		return err
+	}
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		var label string
+		if m := mermaidLink.FindStringSubmatch(rest); m != nil {
+			label, rest = m[1], rest[len(m[0]):]
+		} else if m := mermaidTextLink.FindStringSubmatch(rest); m != nil {
+			label, rest = m[1], rest[len(m[0]):]
+		} else {
+			return fmt.Errorf("expected a link like --> at %q", rest)
+		}
+		var to []string
+		if to, rest, err = p.nodeGroup(rest); err != nil {
+			return err
+		}
+		for _, source := range from {
+			for _, target := range to {
+				p.flow.Edges = append(p.flow.Edges, model.Edge{Source: source, Target: target, Label: mermaidText(label)})
+			}
+		}
+		from = to
+	}
+	return nil
+}
+
+// nodeGroup reads one node or several joined with &, and returns their ids.
+func (p *mermaidParser) nodeGroup(s string) ([]string, string, error) {
+	var ids []string
+	for {
+		node, rest, err := parseMermaidNode(strings.TrimSpace(s))
+		if err != nil {
+			return nil, "", err
+		}
+		p.add(node)
+		ids = append(ids, node.id)
+		rest = strings.TrimSpace(rest)
+		if !strings.HasPrefix(rest, "&") {
+			return ids, rest, nil
+		}
+		s = rest[1:]
+	}
+}
+
+// add puts the node in the chart the first time it shows up, a later
+// statement giving its shape or label updates it.
+func (p *mermaidParser) add(node mermaidNode) {
+	i, ok := p.nodes[node.id]
+	if !ok {
+		i = len(p.flow.Nodes)
+		p.nodes[node.id] = i
+		p.flow.Nodes = append(p.flow.Nodes, model.Node{ID: node.id, Type: "flowChartProcess", Label: node.id})
+	}
+	if node.nodeType != "" {
+		p.flow.Nodes[i].Type = node.nodeType
+		if model.IsBar(node.nodeType) && !p.labelled[node.id] {
+			p.flow.Nodes[i].Label = ""
+		}
+	}
+	if node.hasLabel {
+		p.flow.Nodes[i].Label = node.label
+		p.labelled[node.id] = true
+	}
+	// a node goes in the subgraph it is first named in
+	if _, ok := p.inside[node.id]; !ok && len(p.open) > 0 {
+		p.inside[node.id] = append([]string(nil), p.open...)
+	}
+}
+
+// placeSubgraphs makes swimlanes of the outermost subgraphs when they hold
+// every node, in the order they are written and an empty one too, and
+// groups of the innermost ones otherwise (or for the ones nested in a lane).
+func (p *mermaidParser) placeSubgraphs() {
+	if len(p.inside) == 0 {
+		return
+	}
+	lanes := true
+	for _, node := range p.flow.Nodes {
+		if _, ok := p.inside[node.ID]; !ok && !model.IsBar(node.Type) {
+			lanes = false
+		}
+	}
+	if lanes && len(p.flow.Layout.Lanes) == 0 {
+		p.flow.Layout.Lanes = p.outer
+	}
+	for i, node := range p.flow.Nodes {
+		path, ok := p.inside[node.ID]
+		if !ok {
+			continue
+		}
+		switch {
+		case !lanes:
+			p.flow.Nodes[i].Group = path[len(path)-1]
+		case model.IsBar(node.Type):
+			// a bar spans its branches, whatever lane it was written in
+		default:
+			p.flow.Nodes[i].Actor = path[0]
+			if len(path) > 1 {
+				p.flow.Nodes[i].Group = path[len(path)-1]
+			}
+		}
+	}
+}
+
+// setBranches makes the link of a decision labelled like a true or false
+// branch (Ya, Yes, True...) that branch, so the layout places it the same.
+// With two exits the other one is the other branch.
+func (p *mermaidParser) setBranches() {
+	kinds := map[string]string{model.BranchTrue: model.BranchTrue, model.BranchFalse: model.BranchFalse}
+	for _, labels := range model.BranchLabels {
+		for branch, label := range labels {
+			kinds[strings.ToLower(label)] = branch
+		}
+	}
+	for branch, label := range p.flow.Layout.BranchLabels {
+		if label != "" {
+			kinds[strings.ToLower(label)] = branch
+		}
+	}
+	taken := map[[2]string]bool{}
+	for i, edge := range p.flow.Edges {
+		source := p.flow.Nodes[p.nodes[edge.Source]]
+		branch, ok := kinds[strings.ToLower(edge.Label)]
+		if !ok || model.ShapeType(source.Type) != "flowChartDecision" || taken[[2]string{edge.Source, branch}] {
+			continue
+		}
+		taken[[2]string{edge.Source, branch}] = true
+		p.flow.Edges[i].Branch = branch
+	}
+
+	// the other exit of a two way decision is the other branch, e.g. a
+	// "Belum" next to a "Ya"
+	exits := map[string][]int{}
+	for i, edge := range p.flow.Edges {
+		exits[edge.Source] = append(exits[edge.Source], i)
+	}
+	other := map[string]string{model.BranchTrue: model.BranchFalse, model.BranchFalse: model.BranchTrue}
+	for _, node := range p.flow.Nodes {
+		edges := exits[node.ID]
+		if model.ShapeType(node.Type) != "flowChartDecision" || len(edges) != 2 {
+			continue
+		}
+		a, b := &p.flow.Edges[edges[0]], &p.flow.Edges[edges[1]]
+		if b.Branch == "" && other[a.Branch] != "" {
+			b.Branch = other[a.Branch]
+		} else if a.Branch == "" && other[b.Branch] != "" {
+			a.Branch = other[b.Branch]
+		}
+	}
+}
+
+// parseMermaidNode reads a node id with its optional shape and label,
+// A, A[text], A{"text"}, A@{ shape: doc, label: "text" }, and a :::class
+// after it.
+func parseMermaidNode(s string) (mermaidNode, string, error) {
+	end := 0
+	for end < len(s) {
+		r := rune(s[end])
+		if r >= 0x80 {
+			// multi byte letters are part of the id
+			end++
+			continue
+		}
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			break
+		}
+		end++
+	}
+	node := mermaidNode{id: s[:end]}
+	if node.id == "" {
+		return node, "", fmt.Errorf("expected a node id at %q", s)
+	}
+	rest := s[end:]
+
+	switch {
+	case strings.HasPrefix(rest, "@{"):
+		close := strings.Index(rest, "}")
+		if close < 0 {
+			return node, "", fmt.Errorf("node %q: missing } after @{", node.id)
+		}
+		for _, prop := range splitProps(rest[2:close]) {
+			key, value, _ := strings.Cut(prop, ":")
+			value = unquote(strings.TrimSpace(value))
+			switch strings.TrimSpace(key) {
+			case "shape":
+				nodeType, ok := mermaidShapes[strings.ToLower(value)]
+				if !ok {
+					return node, "", fmt.Errorf("node %q: unknown shape %q", node.id, value)
+				}
+				node.nodeType = nodeType
+			case "label":
+				node.label, node.hasLabel = mermaidText(value), true
+			}
+		}
+		rest = rest[close+1:]
+	default:
+		for _, b := range mermaidBrackets {
+			if !strings.HasPrefix(rest, b.open) {
+				continue
+			}
+			text, after, nodeType, ok := closeBracket(rest, b.open)
+			if !ok {
+				return node, "", fmt.Errorf("node %q: missing the closing bracket of %q", node.id, b.open)
+			}
+			node.nodeType, node.label, node.hasLabel, rest = nodeType, mermaidText(text), true, after
+			break
+		}
+	}
+
+	if strings.HasPrefix(rest, ":::") {
+		rest = rest[3:]
+		for rest != "" && (unicode.IsLetter(rune(rest[0])) || unicode.IsDigit(rune(rest[0])) || rest[0] == '_' || rest[0] == '-') {
+			rest = rest[1:]
+		}
+	}
+	return node, rest, nil
+}
+
+// closeBracket finds the end of the shape opened with open: the nearest of
+// its closings, after the quotes when the text is quoted.
+func closeBracket(s, open string) (text, rest, nodeType string, ok bool) {
+	body := s[len(open):]
+	from := 0
+	if trimmed := strings.TrimLeft(body, " "); strings.HasPrefix(trimmed, `"`) {
+		start := len(body) - len(trimmed)
+		if q := strings.Index(trimmed[1:], `"`); q >= 0 {
+			from = start + q + 2
+		}
+	}
+	best := -1
+	for _, b := range mermaidBrackets {
+		if b.open != open {
+			continue
+		}
+		if i := strings.Index(body[from:], b.close); i >= 0 && (best < 0 || from+i < best) {
+			best, nodeType = from+i, b.nodeType
+			rest = body[from+i+len(b.close):]
+		}
+	}
+	if best < 0 {
+		return "", "", "", false
+	}
+	return body[:best], rest, nodeType, true
+}
+
+// parseSubgraph reads "id [Title]", "id[Title]", "\"Title\"" or a bare title,
+// which is the id as well.
+func parseSubgraph(s string) (id, title string) {
+	if open := strings.Index(s, "["); open >= 0 && strings.HasSuffix(s, "]") {
+		id = strings.TrimSpace(s[:open])
+		title = mermaidText(s[open+1 : len(s)-1])
+		if id == "" {
+			id = title
+		}
+		return id, title
+	}
+	title = unquote(s)
+	return title, title
+}
+
+// splitStatements splits a line at the semicolons outside quotes and
+// brackets.
+func splitStatements(line string) []string {
+	var statements []string
+	depth, quoted, start := 0, false, 0
+	for i, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == '[' || r == '(' || r == '{':
+			depth++
+		case (r == ']' || r == ')' || r == '}') && depth > 0:
+			depth--
+		case r == ';' && depth == 0:
+			statements = append(statements, line[start:i])
+			start = i + 1
+		}
+	}
+	return append(statements, line[start:])
+}
+
+// splitProps splits the properties of an @{ } at the commas outside quotes.
+func splitProps(s string) []string {
+	var props []string
+	quoted, start := false, 0
+	for i, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			props = append(props, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(props, s[start:])
+}
+
+// mermaidText is the text of a label: unquoted, with <br> as a line break
+// and the entity codes (#quot;, #124;) as their character.
+func mermaidText(s string) string {
+	s = unquote(strings.TrimSpace(s))
+	s = mermaidEntity.ReplaceAllStringFunc(s, func(code string) string {
+		switch name := code[1 : len(code)-1]; name {
+		case "quot":
+			return `"`
+		case "amp":
+			return "&"
+		case "lt":
+			return "<"
+		case "gt":
+			return ">"
+		default:
+			n, _ := strconv.Atoi(name)
+			return string(rune(n))
+		}
+	})
+	return strings.TrimSpace(mermaidBreak.ReplaceAllString(s, "\n"))
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"go_excelize/internal/app/model"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// mermaidOut is how a preset is written in Mermaid: in the brackets of the
+// classic syntax when it has the shape, otherwise by its name in the
+// A@{ shape: doc } syntax. A preset Mermaid has no shape for takes the
+// closest one, ParseMermaid reads every one of them back. Every preset has
+// a name, a node without a label is written with it.
+var mermaidOut = map[string]struct{ open, close, shape string }{
+	"flowChartProcess":           {open: "[", close: "]", shape: "rect"},
+	"rect":                       {open: "[", close: "]", shape: "rect"},
+	"flowChartAlternateProcess":  {open: "(", close: ")", shape: "rounded"},
+	"roundRect":                  {open: "(", close: ")", shape: "rounded"},
+	"flowChartTerminator":        {open: "([", close: "])", shape: "stadium"},
+	"flowChartPredefinedProcess": {open: "[[", close: "]]", shape: "subproc"},
+	"flowChartMagneticDisk":      {open: "[(", close: ")]", shape: "cyl"},
+	"can":                        {open: "[(", close: ")]", shape: "cyl"},
+	"flowChartConnector":         {open: "((", close: "))", shape: "circle"},
+	"ellipse":                    {open: "((", close: "))", shape: "circle"},
+	"flowChartDecision":          {open: "{", close: "}", shape: "diam"},
+	"diamond":                    {open: "{", close: "}", shape: "diam"},
+	"flowChartSort":              {open: "{", close: "}", shape: "diam"},
+	"flowChartPreparation":       {open: "{{", close: "}}", shape: "hex"},
+	"hexagon":                    {open: "{{", close: "}}", shape: "hex"},
+	"flowChartInputOutput":       {open: "[/", close: "/]", shape: "lean-r"},
+	"parallelogram":              {open: "[/", close: "/]", shape: "lean-r"},
+	"flowChartManualOperation":   {open: `[\`, close: "/]", shape: "trap-t"},
+	"flowChartOffpageConnector":  {open: ">", close: "]", shape: "odd"},
+	"flowChartDocument":          {shape: "doc"},
+	"flowChartManualInput":       {shape: "sl-rect"},
+	"flowChartDisplay":           {shape: "curv-trap"},
+	"flowChartDelay":             {shape: "delay"},
+	"flowChartInternalStorage":   {shape: "win-pane"},
+	"flowChartCollate":           {shape: "hourglass"},
+	"flowChartExtract":           {shape: "tri"},
+	"triangle":                   {shape: "tri"},
+	"flowChartMerge":             {shape: "flip-tri"},
+	"flowChartSummingJunction":   {shape: "cross-circ"},
+	"flowChartOr":                {shape: "cross-circ"},
+	"flowChartPunchedCard":       {shape: "notch-rect"},
+	model.NodeFork:               {shape: "fork"},
+	model.NodeJoin:               {shape: "join"},
+}
+
+// mermaidKeywords can not be node ids, Mermaid (or ParseMermaid) would read
+// the statement as something else.
+var mermaidKeywords = append([]string{"end", "subgraph", "graph", "flowchart"}, mermaidIgnored...)
+
+// mermaidPlain is an edge label that needs no quotes.
+var mermaidPlain = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N} .,?!'_-]*$`)
+
+// RenderMermaid writes the flowchart as a Mermaid flowchart, for a README
+// or a wiki: the closest Mermaid shape of every node with its label, the
+// edges with their labels, the direction, and the swimlanes as subgraphs
+// with the node groups nested in them. Sizes, columns and colors stay in
+// the other outputs. POST /excel/mermaid reads it back as the same chart.
+func (s *ExcelService) RenderMermaid(flow *model.Flowchart) ([]byte, error) {
+	return renderMermaid(flow), nil
+}
+
+func renderMermaid(flow *model.Flowchart) []byte {
+	var buf bytes.Buffer
+	if title := flow.Metadata["title"]; title != "" {
+		fmt.Fprintf(&buf, "---\ntitle: %s\n---\n", strconv.Quote(title))
+	}
+	direction := flow.Layout.Direction
+	if direction == "" {
+		direction = model.DefaultDirection
+	}
+	fmt.Fprintf(&buf, "flowchart %s\n", direction)
+
+	// every node in the order of the chart, the subgraphs then only name
+	// them so the order comes back the same
+	ids := mermaidIDs(flow)
+	for _, node := range flow.Nodes {
+		fmt.Fprintf(&buf, "    %s%s\n", ids[node.ID], mermaidShape(node))
+	}
+
+	lanes := flow.LaneNames()
+	taken := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		taken[id] = true
+	}
+	subgraphID := func() string {
+		for n := 1; ; n++ {
+			if id := "sg" + strconv.Itoa(n); !taken[id] {
+				taken[id] = true
+				return id
+			}
+		}
+	}
+	// groups goes through the groups of the nodes picked by in, in the
+	// order they show up, as subgraphs at the indent
+	groups := func(in func(node model.Node) bool, indent string) []string {
+		var names, lines []string
+		for _, node := range flow.Nodes {
+			if in(node) && node.Group != "" && !slices.Contains(names, node.Group) {
+				names = append(names, node.Group)
+			}
+		}
+		for _, name := range names {
+			lines = append(lines, fmt.Sprintf("%ssubgraph %s [%s]", indent, subgraphID(), mermaidLabel(name)))
+			for _, node := range flow.Nodes {
+				if in(node) && node.Group == name {
+					lines = append(lines, indent+"    "+ids[node.ID])
+				}
+			}
+			lines = append(lines, indent+"end")
+		}
+		return lines
+	}
+	var lines []string
+	if len(lanes) == 0 {
+		lines = groups(func(model.Node) bool { return true }, "    ")
+	}
+	for _, lane := range lanes {
+		lines = append(lines, fmt.Sprintf("    subgraph %s [%s]", subgraphID(), mermaidLabel(lane)))
+		for _, node := range flow.Nodes {
+			if node.Actor == lane && node.Group == "" {
+				lines = append(lines, "        "+ids[node.ID])
+			}
+		}
+		lines = append(lines, groups(func(node model.Node) bool { return node.Actor == lane }, "        ")...)
+		lines = append(lines, "    end")
+	}
+	for _, line := range lines {
+		buf.WriteString(line + "\n")
+	}
+
+	for _, edge := range flow.Edges {
+		link := "-->"
+		if edge.Label != "" {
+			label := mermaidEscape(edge.Label)
+			if !mermaidPlain.MatchString(edge.Label) {
+				label = `"` + label + `"`
+			}
+			link += "|" + label + "|"
+		}
+		fmt.Fprintf(&buf, "    %s %s %s\n", ids[edge.Source], link, ids[edge.Target])
+	}
+	return buf.Bytes()
+}
+
+// mermaidShape is the shape and label written after the node id. A node
+// without a label gets none, Mermaid then shows its id (and a plain process
+// needs nothing after it).
+func mermaidShape(node model.Node) string {
+	out, ok := mermaidOut[node.Type]
+	if !ok {
+		out = mermaidOut["flowChartProcess"]
+	}
+	switch {
+	case node.Label == "" && out.shape == "rect":
+		return ""
+	case node.Label == "":
+		return "@{ shape: " + out.shape + " }"
+	case out.open != "":
+		return out.open + mermaidLabel(node.Label) + out.close
+	}
+	return "@{ shape: " + out.shape + ", label: " + mermaidLabel(node.Label) + " }"
+}
+
+// mermaidLabel is a node label in quotes, so any text goes.
+func mermaidLabel(text string) string {
+	return `"` + mermaidEscape(text) + `"`
+}
+
+// mermaidEscape writes the characters Mermaid would read as syntax as entity
+// codes and the line breaks as <br>.
+func mermaidEscape(text string) string {
+	return strings.NewReplacer(`"`, "#quot;", "|", "#124;", "\r\n", "<br>", "\n", "<br>").Replace(text)
+}
+
+// mermaidIDs gives every node an id Mermaid can read: letters, digits and
+// underscores, not a keyword, and still unique.
+func mermaidIDs(flow *model.Flowchart) map[string]string {
+	ids := make(map[string]string, len(flow.Nodes))
+	taken := make(map[string]bool, len(flow.Nodes))
+	for _, node := range flow.Nodes {
+		id := strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+				return r
+			}
+			return '_'
+		}, node.ID)
+		if id == "" || slices.Contains(mermaidKeywords, id) {
+			id += "_"
+		}
+		for base, n := id, 2; taken[id]; n++ {
+			id = base + "_" + strconv.Itoa(n)
+		}
+		taken[id] = true
+		ids[node.ID] = id
+	}
+	return ids
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"go_excelize/internal/app/model"
+	"testing"
+)
+
+// mermaidRoundTrip parses the text the way POST /excel/mermaid does and
+// writes it back.
+func mermaidRoundTrip(t *testing.T, text string) string {
+	t.Helper()
+	flow, err := ParseMermaid(&model.MermaidRequest{Mermaid: text})
+	if err != nil {
+		t.Fatalf("ParseMermaid() error = %v", err)
+	}
+	flow.ApplyDefaults()
+	if err := flow.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	return string(renderMermaid(flow))
+}
+
+func TestMermaidRoundTrip(t *testing.T) {
+	tests := []struct {
+		name, in, want string // want empty: the text comes back as it is
+	}{
+		{
+			name: "shapes and labels",
+			in: `flowchart TB
+    a(["Mulai"])
+    b[/"Isi formulir"/]
+    c{"Lengkap?"}
+    d[["Verifikasi"]]
+    e[("Arsip")]
+    f@{ shape: doc, label: "Cetak kartu" }
+    a --> b
+    b --> c
+    c -->|Ya| d
+    c -->|Tidak| b
+    d --> e
+    e --> f
+`,
+		},
+		{
+			name: "lanes with a fork",
+			in: `---
+title: "Pendaftaran"
+---
+flowchart LR
+    a["Isi formulir"]
+    f@{ shape: fork }
+    b["Cek berkas"]
+    c["Cek pembayaran"]
+    j@{ shape: join }
+    subgraph sg1 ["Mahasiswa"]
+        a
+    end
+    subgraph sg2 ["Admin"]
+        b
+    end
+    subgraph sg3 ["Keuangan"]
+        c
+    end
+    a --> f
+    f --> b
+    f --> c
+    b --> j
+    c --> j
+`,
+		},
+		{
+			name: "escaped text and renamed ids",
+			in: `flowchart TB
+    end_["say #quot;hi#quot;<br>a #124; b"]
+    a_b["x"]
+    end_ -->|"a #124; b"| a_b
+`,
+		},
+		{
+			name: "classic syntax is written back quoted",
+			in: `graph TD
+    A[Start] --> B{Ok?}
+    B -- Yes --> C[Done]
+    B -- No --> A
+`,
+			want: `flowchart TB
+    A["Start"]
+    B{"Ok?"}
+    C["Done"]
+    A --> B
+    B -->|Yes| C
+    B -->|No| A
+`,
+		},
+		{
+			name: "nodes without label show their id",
+			in: `flowchart TB
+    a
+    b@{ shape: diam }
+    a --> b
+    b -->|x| a
+    b -->|y| c
+`,
+			want: `flowchart TB
+    a["a"]
+    b{"b"}
+    c["c"]
+    a --> b
+    b -->|x| a
+    b -->|y| c
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want == "" {
+				want = tt.in
+			}
+			got := mermaidRoundTrip(t, tt.in)
+			if got != want {
+				t.Errorf("round trip =\n%s\nwant\n%s", got, want)
+			}
+			// what comes out reads back the same
+			if again := mermaidRoundTrip(t, got); again != got {
+				t.Errorf("second round trip =\n%s\nwant\n%s", again, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"go_excelize/internal/app/model"
+	"slices"
+	"testing"
+)
+
+func TestParseMermaidErrors(t *testing.T) {
+	tests := []struct {
+		name, in string
+		fields   []string
+	}{
+		{"no header", "a --> b", []string{"mermaid line 1"}},
+		{"unknown shape", "flowchart TB\n    a@{ shape: blob }", []string{"mermaid line 2"}},
+		{"open subgraph", "flowchart TB\n    subgraph x\n    a --> b", []string{"mermaid"}},
+		{"empty", "flowchart TB\n", []string{"mermaid"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseMermaid(&model.MermaidRequest{Mermaid: tt.in})
+			fields, ok := model.ErrorFields(err)
+			if !ok || err == nil {
+				t.Fatalf("ParseMermaid() error = %v, want ValidationErrors", err)
+			}
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("ParseMermaid() error fields = %q, want %q", fields, tt.fields)
+			}
+		})
+	}
+}
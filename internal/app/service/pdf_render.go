@@ -0,0 +1,373 @@
+package service
+
+import (
+	"fmt"
+	"go_excelize/internal/app/model"
+	"math"
+	"sort"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Paper sizes in points, portrait.
+var paperSizes = map[string][2]float64{
+	"a4":     {595.28, 841.89},
+	"letter": {612, 792},
+}
+
+// Page furniture, in points.
+const (
+	pageMargin     = 36.0
+	pageHeaderSize = 12.0 // title
+	pageFooterSize = 9.0  // page number
+	pageBandHeight = 24.0 // room kept for the header and the footer each
+	// at most the size on the sheet: 96 dpi pixels are 0.75 points
+	maxPageScale = 0.75
+)
+
+// Off-page connectors, in scene pixels.
+const (
+	offPageWidth   = 40.0
+	offPageHeight  = 32.0
+	offPageGap     = 24.0 // between the marker and the shapes
+	offPageCaption = 7.0  // font size in points of "to page 2"
+)
+
+// PDFOptions are the page settings of RenderPDF.
+type PDFOptions struct {
+	Paper     string // a4 (default) or letter
+	Landscape bool
+	// Title printed on top of every page and stored in the document info.
+	// Empty takes the "title" metadata of the flowchart, then "Flowchart".
+	Title string
+}
+
+// pdfPage is the part of the chart drawn on one page.
+type pdfPage struct {
+	scene    *scene
+	captions []textLine // next to the off-page connectors, left aligned
+}
+
+// RenderPDF draws the flowchart on paper-sized pages. A chart that does not
+// fit one page is split between ranks of shapes along the flow, the edges
+// that cross pages end on off-page connectors with the same letter on both
+// pages.
+func (s *ExcelService) RenderPDF(flow *model.Flowchart, opts PDFOptions) ([]byte, error) {
+	if opts.Paper == "" {
+		opts.Paper = "a4"
+	}
+	size, ok := paperSizes[opts.Paper]
+	if !ok {
+		return nil, model.ValidationErrors{{Field: "paper", Message: fmt.Sprintf("unknown paper %q, use a4 or letter", opts.Paper)}}
+	}
+	if opts.Landscape {
+		size[0], size[1] = size[1], size[0]
+	}
+	if opts.Title == "" {
+		opts.Title = flow.Metadata["title"]
+	}
+	if opts.Title == "" {
+		opts.Title = "Flowchart"
+	}
+
+	sc := layoutScene(flow, defaultOrigin(flow.Layout))
+	contentW := size[0] - 2*pageMargin
+	contentH := size[1] - 2*pageMargin - 2*pageBandHeight
+	// the chart is fit across the page and split along the flow, down the
+	// pages for TB and BT and across them for LR and RL
+	length, breadth := contentH, contentW
+	whole := sc.bounds()
+	if across(flow.Layout.Direction) {
+		length, breadth = contentW, contentH
+		whole.w, whole.h = whole.h, whole.w
+	}
+	scale := maxPageScale
+	if whole.w > 0 {
+		scale = math.Min(scale, breadth/whole.w)
+	}
+
+	doc := &pdfDocument{title: opts.Title, size: size}
+	pages := paginate(flow, sc, length/scale)
+	for i, page := range pages {
+		doc.addPage(drawPDFPage(page, opts.Title, i+1, len(pages), size, scale))
+	}
+	return doc.bytes()
+}
+
+// paginate splits the scene into pages of at most maxLength scene pixels
+// along the flow, keeping every rank of shapes whole, in the order the flow
+// runs. It works in the flow frame (see direction.go), so a page ends under
+// a rank for TB and right of it for LR. The edges are routed again on every
+// page, an edge to another page goes to an off-page connector after its
+// source and comes from one before its target.
+func paginate(flow *model.Flowchart, sc *scene, maxLength float64) []*pdfPage {
+	dir := flow.Layout.Direction
+	if rectToFlow(dir, sc.bounds()).h <= maxLength {
+		return []*pdfPage{{scene: sc}}
+	}
+
+	// the ranks of the layout, a rank spans its highest shape, bar and note
+	type shapeRank struct {
+		top, bottom float64
+		nodes       []sceneNode
+		notes       []sceneNote
+	}
+	var ranks []*shapeRank
+	byRank := map[int]*shapeRank{}
+	rankOf := map[string]*shapeRank{}
+	for _, n := range sc.nodes {
+		box := rectToFlow(dir, n.box)
+		rank, ok := byRank[n.rank]
+		if !ok {
+			rank = &shapeRank{top: box.y, bottom: box.bottom()}
+			byRank[n.rank] = rank
+			ranks = append(ranks, rank)
+		}
+		rank.top = math.Min(rank.top, box.y)
+		rank.bottom = math.Max(rank.bottom, box.bottom())
+		rank.nodes = append(rank.nodes, n)
+		rankOf[n.node.ID] = rank
+	}
+	for _, n := range sc.notes {
+		box, rank := rectToFlow(dir, n.box), rankOf[n.node]
+		rank.top = math.Min(rank.top, box.y)
+		rank.bottom = math.Max(rank.bottom, box.bottom())
+		rank.notes = append(rank.notes, n)
+	}
+	sort.Slice(ranks, func(i, j int) bool { return ranks[i].top < ranks[j].top })
+
+	// room for the connectors before and after the shapes of a page
+	room := maxLength - 2*(offPageLength(dir)+offPageGap)
+	var pages []*pdfPage
+	var tops, bottoms []float64
+	pageOf := map[string]int{}
+	boxes := map[string]rect{}
+	for _, rank := range ranks {
+		last := len(pages) - 1
+		if last < 0 || rank.bottom-tops[last] > room {
+			pages = append(pages, &pdfPage{scene: &scene{}})
+			tops = append(tops, rank.top)
+			bottoms = append(bottoms, rank.bottom)
+			last++
+		}
+		bottoms[last] = math.Max(bottoms[last], rank.bottom)
+		pages[last].scene.nodes = append(pages[last].scene.nodes, rank.nodes...)
+		pages[last].scene.notes = append(pages[last].scene.notes, rank.notes...)
+		for _, n := range rank.nodes {
+			pageOf[n.node.ID] = last
+			boxes[n.node.ID] = rectToFlow(dir, n.box)
+		}
+	}
+
+	fontSize := math.Min(flow.Layout.FontSize, 10)
+	edges := make([][]model.Edge, len(pages))
+	refs := 0
+	for _, edge := range flow.Edges {
+		from, ok1 := pageOf[edge.Source]
+		to, ok2 := pageOf[edge.Target]
+		if !ok1 || !ok2 {
+			continue
+		}
+		if from == to {
+			edges[from] = append(edges[from], edge)
+			continue
+		}
+		refs++
+		ref, _ := excelize.ColumnNumberToName(refs)
+		out := pages[from].addOffPage(ref, dir, point{boxes[edge.Source].center().x, bottoms[from] + offPageGap}, fmt.Sprintf("to page %d", to+1), fontSize)
+		in := pages[to].addOffPage(ref, dir, point{boxes[edge.Target].center().x, tops[to] - offPageGap - offPageLength(dir)}, fmt.Sprintf("from page %d", from+1), fontSize)
+		edges[from] = append(edges[from], model.Edge{Source: edge.Source, Target: out, Branch: edge.Branch})
+		edges[to] = append(edges[to], model.Edge{Source: in, Target: edge.Target})
+	}
+
+	for i, page := range pages {
+		// the lanes run on through the off-page connectors and carry their
+		// header onto every page
+		top, bottom := math.Inf(-1), math.Inf(1)
+		if i > 0 {
+			top = tops[i] - 2*offPageGap - offPageLength(dir)
+		}
+		if i < len(pages)-1 {
+			bottom = bottoms[i] + 2*offPageGap + offPageLength(dir)
+		}
+		a, b := fromFlow(dir, point{0, top}), fromFlow(dir, point{0, bottom})
+		start, end := math.Min(a.y, b.y), math.Max(a.y, b.y)
+		if across(dir) {
+			start, end = math.Min(a.x, b.x), math.Max(a.x, b.x)
+		}
+		for _, lane := range sc.lanes {
+			if l, ok := lane.clipped(start, end); ok {
+				page.scene.lanes = append(page.scene.lanes, l)
+			}
+		}
+
+		pageBoxes := make(map[string]rect, len(page.scene.nodes))
+		for _, n := range page.scene.nodes {
+			pageBoxes[n.node.ID] = n.box
+		}
+		for _, n := range page.scene.notes {
+			pageBoxes[noteKey(n.node)] = n.box
+		}
+		page.scene.routes = routeEdges(&model.Flowchart{Nodes: flow.Nodes, Edges: edges[i], Layout: flow.Layout}, pageBoxes, float64(flow.Layout.Pad))
+		page.scene.labels = placeEdgeLabels(page.scene.routes, page.scene.nodes, flow.Layout.FontSize)
+	}
+	return pages
+}
+
+// offPageLength is how long an off-page connector is along the flow.
+func offPageLength(direction string) float64 {
+	if across(direction) {
+		return offPageWidth
+	}
+	return offPageHeight
+}
+
+// addOffPage puts an off-page connector at a point of the flow frame, its
+// middle across the flow and its start along it, moved across past the ones
+// already there, and returns its node id. The caption goes right of it, or
+// under it when the flow runs across and the shapes are on its right.
+func (p *pdfPage) addOffPage(ref, dir string, at point, caption string, fontSize float64) string {
+	var size point // across and along the flow
+	if across(dir) {
+		size = point{offPageHeight, offPageWidth}
+	} else {
+		size = point{offPageWidth, offPageHeight}
+	}
+	flowBox := rect{at.x - size.x/2, at.y, size.x, size.y}
+	for moved := true; moved; {
+		moved = false
+		for _, n := range p.scene.nodes {
+			if n.node.Type != offPageType {
+				continue
+			}
+			other := rectToFlow(dir, n.box)
+			if other.y == flowBox.y && other.x < flowBox.right()+offPageGap && flowBox.x < other.right()+offPageGap {
+				flowBox.x = other.right() + offPageGap*3
+				moved = true
+			}
+		}
+	}
+	box := rectFromFlow(dir, flowBox)
+	id := fmt.Sprintf("\x00offpage%d", len(p.scene.nodes))
+	p.scene.nodes = append(p.scene.nodes, sceneNode{
+		node:  model.Node{ID: id, Type: offPageType, Label: ref},
+		box:   box,
+		label: fitLabel(ref, offPageType, box.w, box.h, fontSize, true),
+	})
+	line := textLine{text: caption, x: box.right() + 4, y: box.center().y + 3}
+	if across(dir) {
+		width := timesTextWidth(caption, offPageCaption*pointsToPixels)
+		line = textLine{text: caption, x: box.center().x - width/2, y: box.bottom() + offPageCaption*pointsToPixels + 2}
+	}
+	p.captions = append(p.captions, line)
+	return id
+}
+
+const offPageType = "flowChartOffpageConnector"
+
+// drawPDFPage draws the scene of the page under the title, as large as the
+// sheet when it fits and centered across the page.
+func drawPDFPage(page *pdfPage, title string, number, count int, size [2]float64, scale float64) []byte {
+	sc := page.scene
+	view := sc.bounds()
+	for _, c := range page.captions {
+		width := timesTextWidth(c.text, offPageCaption*pointsToPixels)
+		view = view.union(rect{c.x, c.y - offPageCaption, width, offPageCaption})
+	}
+	contentW := size[0] - 2*pageMargin
+	contentH := size[1] - 2*pageMargin - 2*pageBandHeight
+	if view.w > 0 && view.h > 0 {
+		// a page can be wider than the chart with the connectors moved aside
+		scale = math.Min(scale, math.Min(contentW/view.w, contentH/view.h))
+	}
+	c := &pdfContent{
+		origin: point{view.x, view.y},
+		left:   pageMargin + (contentW-view.w*scale)/2,
+		top:    size[1] - pageMargin - pageBandHeight,
+		scale:  scale,
+	}
+
+	// header: title and a rule, footer: the page number
+	heading := fitTitle(title, contentW, pageHeaderSize)
+	c.pageText(heading, pageMargin, size[1]-pageMargin-pageHeaderSize, pdfFontBold, pageHeaderSize, "000000")
+	fmt.Fprintf(&c.buf, "0 0 0 RG 0.5 w %s %s m %s %s l S\n",
+		pdfNum(pageMargin), pdfNum(size[1]-pageMargin-pageHeaderSize-6), pdfNum(size[0]-pageMargin), pdfNum(size[1]-pageMargin-pageHeaderSize-6))
+	footer := fmt.Sprintf("Page %d of %d", number, count)
+	c.pageText(footer, (size[0]-timesTextWidth(footer, pageFooterSize))/2, pageMargin, pdfFontRegular, pageFooterSize, "000000")
+
+	// back to front, the same layers as the xlsx, the captions go with the
+	// off-page connectors
+	for _, d := range sc.drawables() {
+		switch {
+		case d.lane != nil:
+			l := d.lane
+			c.style(l.fill(), laneLineColor, laneLineWidth*pointsToPixels)
+			c.path(rectPath(l.band), "B")
+			c.style(laneHeaderColor, laneLineColor, laneLineWidth*pointsToPixels)
+			c.path(rectPath(l.header), "B")
+			fontPx := l.label.size * pointsToPixels
+			for _, line := range labelLines(l.label, l.header) {
+				width := timesTextWidth(line.text, fontPx)
+				c.text(line.text, point{line.x - width/2, line.y}, l.label.size, laneFontColor)
+			}
+		case d.node != nil:
+			n := d.node
+			fill, line, font := nodeColors(n.node)
+			outline, inner := shapeOutline(n.node.Type, n.box)
+			c.style(fill, line, nodeLineWidth*pointsToPixels)
+			c.path(outline, "B")
+			if inner != nil {
+				c.path(inner, "S")
+			}
+			fontPx := n.label.size * pointsToPixels
+			for _, l := range labelLines(n.label, textArea(n.node.Type, n.box)) {
+				width := timesTextWidth(l.text, fontPx)
+				c.text(l.text, point{l.x - width/2, l.y}, n.label.size, font)
+			}
+		case d.route != nil:
+			if len(d.route.points) < 2 {
+				continue
+			}
+			line, head := arrowLine(d.route.points)
+			c.style(connectorColor, connectorColor, connectorLineWidth*pointsToPixels)
+			c.path(openPath(line), "S")
+			c.path(polygon(head[:]...), "f")
+		case d.label != nil:
+			l := d.label
+			fontPx := l.label.size * pointsToPixels
+			for _, line := range labelLines(l.label, l.box) {
+				width := timesTextWidth(line.text, fontPx)
+				c.text(line.text, point{line.x - width/2, line.y}, l.label.size, edgeLabelColor)
+			}
+		case d.note != nil:
+			n := d.note
+			c.style(noteLineColor, noteLineColor, noteLineWidth*pointsToPixels)
+			c.dash(noteDash)
+			c.path(openPath(n.link[:]), "S")
+			c.dash(0)
+			c.path(openPath(n.bracket()), "S")
+			fontPx := n.label.size * pointsToPixels
+			for _, line := range labelLines(n.label, n.box) {
+				width := timesTextWidth(line.text, fontPx)
+				c.text(line.text, point{line.x - width/2, line.y}, n.label.size, noteFontColor)
+			}
+		}
+	}
+	for _, caption := range page.captions {
+		c.text(caption.text, point{caption.x, caption.y}, offPageCaption, "000000")
+	}
+	return c.buf.Bytes()
+}
+
+// fitTitle cuts the title to the width with an ellipsis. Times-Bold is a bit
+// wider than the Times-Roman widths, hence the 10% kept free.
+func fitTitle(title string, width, size float64) string {
+	if timesTextWidth(title, size)*1.1 <= width {
+		return title
+	}
+	runes := []rune(title)
+	for len(runes) > 0 && timesTextWidth(string(runes)+"…", size)*1.1 > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// --- A minimal PDF 1.4 writer for the PDF output ---
+//
+// The pages only use vector paths and the standard Times fonts, which every
+// reader has, so nothing is embedded and the file stays small.
+
+// Fonts of the page resources.
+const (
+	pdfFontRegular = "F1" // Times-Roman, the node labels
+	pdfFontBold    = "F2" // Times-Bold, the page title
+)
+
+// pdfDocument collects the page contents and writes the file.
+type pdfDocument struct {
+	title string
+	size  [2]float64 // page width and height in points
+	pages [][]byte   // content streams
+}
+
+func (d *pdfDocument) addPage(content []byte) {
+	d.pages = append(d.pages, content)
+}
+
+// bytes writes the catalog, the page tree, the fonts, the info dictionary
+// and then every page with its content stream, in this object order.
+func (d *pdfDocument) bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	const firstPage = 6
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Times-Roman /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Times-Bold /Encoding /WinAnsiEncoding >>")
+	object(fmt.Sprintf("<< /Title %s /Producer (go_excelize) >>", pdfTextString(d.title)))
+	for i, content := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /%s 3 0 R /%s 4 0 R >> >> /Contents %d 0 R >>",
+			pdfNum(d.size[0]), pdfNum(d.size[1]), pdfFontRegular, pdfFontBold, firstPage+2*i+1))
+
+		var packed bytes.Buffer
+		zw := zlib.NewWriter(&packed)
+		if _, err := zw.Write(content); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", packed.Len(), packed.Bytes()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes(), nil
+}
+
+// pdfContent is the content stream of a page. Scene points are drawn with
+// origin at (left, top) of the page, scale points per scene pixel.
+type pdfContent struct {
+	buf       bytes.Buffer
+	origin    point
+	left, top float64
+	scale     float64
+}
+
+// at converts a scene point to page points, y going up.
+func (c *pdfContent) at(p point) string {
+	return pdfNum(c.left+(p.x-c.origin.x)*c.scale) + " " + pdfNum(c.top-(p.y-c.origin.y)*c.scale)
+}
+
+// path adds the path and paints it: "f" fill, "S" stroke, "B" both.
+func (c *pdfContent) path(p path, paint string) {
+	for _, op := range p {
+		switch op.op {
+		case 'M':
+			fmt.Fprintf(&c.buf, "%s m\n", c.at(op.pts[0]))
+		case 'L':
+			fmt.Fprintf(&c.buf, "%s l\n", c.at(op.pts[0]))
+		case 'C':
+			fmt.Fprintf(&c.buf, "%s %s %s c\n", c.at(op.pts[0]), c.at(op.pts[1]), c.at(op.pts[2]))
+		case 'Z':
+			c.buf.WriteString("h\n")
+		}
+	}
+	c.buf.WriteString(paint + "\n")
+}
+
+// openPath is the polyline through the points.
+func openPath(points []point) path {
+	var p path
+	for i, pt := range points {
+		if i == 0 {
+			p.moveTo(pt.x, pt.y)
+		} else {
+			p.lineTo(pt.x, pt.y)
+		}
+	}
+	return p
+}
+
+// style sets the fill and stroke colors (RRGGBB) and the line width in
+// scene pixels.
+func (c *pdfContent) style(fill, line string, width float64) {
+	fmt.Fprintf(&c.buf, "%s rg %s RG %s w\n", pdfColor(fill), pdfColor(line), pdfNum(width*c.scale))
+}
+
+// dash strokes the next lines in dashes of the length in scene pixels, with
+// as much space after each, 0 goes back to solid lines.
+func (c *pdfContent) dash(length float64) {
+	if length == 0 {
+		c.buf.WriteString("[] 0 d\n")
+		return
+	}
+	fmt.Fprintf(&c.buf, "[%s] 0 d\n", pdfNum(length*c.scale))
+}
+
+// text writes the text with its baseline starting at the scene point, size
+// in scene points.
+func (c *pdfContent) text(s string, at point, size float64, color string) {
+	c.pageText(s, c.left+(at.x-c.origin.x)*c.scale, c.top-(at.y-c.origin.y)*c.scale, pdfFontRegular, size*c.scale*pointsToPixels, color)
+}
+
+// pageText writes the text at page points, e.g. the title and page number.
+func (c *pdfContent) pageText(s string, x, y float64, font string, size float64, color string) {
+	fmt.Fprintf(&c.buf, "BT %s rg /%s %s Tf %s %s Td %s Tj ET\n", pdfColor(color), font, pdfNum(size), pdfNum(x), pdfNum(y), pdfString(s))
+}
+
+func pdfNum(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+func pdfColor(hex string) string {
+	rgb := hexColor(hex)
+	return pdfNum(float64(rgb.R)/255) + " " + pdfNum(float64(rgb.G)/255) + " " + pdfNum(float64(rgb.B)/255)
+}
+
+// pdfString is a literal string in WinAnsi, the encoding of the fonts. What
+// the encoding does not have becomes '?'.
+func pdfString(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		c := winAnsiByte(r)
+		if c == '(' || c == ')' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// pdfTextString is a string for the document info, UTF-16 so any title works.
+func pdfTextString(s string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	b.WriteByte('>')
+	return b.String()
+}
+
+// winAnsiSpecials are the characters of 0x80-0x9F in WinAnsi, the rest of
+// 0xA0-0xFF is Latin-1.
+var winAnsiSpecials = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+func winAnsiByte(r rune) byte {
+	switch {
+	case r >= 0x20 && r < 0x7F, r >= 0xA0 && r <= 0xFF:
+		return byte(r)
+	}
+	if c, ok := winAnsiSpecials[r]; ok {
+		return c
+	}
+	return '?'
+}
+
+// timesWidths are the advance widths of Times-Roman for ASCII 0x20-0x7E, in
+// thousandths of an em (from the font's AFM).
+var timesWidths = [95]int{
+	250, 333, 408, 500, 500, 833, 778, 180, 333, 333, 500, 564, 250, 333, 250, 278, // space to /
+	500, 500, 500, 500, 500, 500, 500, 500, 500, 500, // 0-9
+	278, 278, 564, 564, 564, 444, 921, // : to @
+	722, 667, 667, 722, 611, 556, 722, 722, 333, 389, 722, 611, 889, // A-M
+	722, 722, 556, 722, 667, 556, 611, 722, 722, 944, 722, 722, 611, // N-Z
+	333, 278, 333, 469, 500, 333, // [ to `
+	444, 500, 444, 500, 444, 333, 500, 500, 278, 278, 500, 278, 778, // a-m
+	500, 500, 500, 500, 333, 389, 278, 500, 500, 722, 500, 500, 444, // n-z
+	480, 200, 480, 541, // { to ~
+}
+
+// timesTextWidth is the width of the text in Times-Roman at size.
+func timesTextWidth(s string, size float64) float64 {
+	total := 0
+	for _, r := range s {
+		c := winAnsiByte(r)
+		switch {
+		case c >= 0x20 && c < 0x7F:
+			total += timesWidths[c-0x20]
+		case r >= 0xC0 && r <= 0xDE:
+			total += 722 // accented capitals
+		default:
+			total += 500
+		}
+	}
+	return float64(total) / 1000 * size
+}
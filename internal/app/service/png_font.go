@@ -0,0 +1,106 @@
+package service
+
+import (
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// labelFont is the font of the PNG labels, Go Regular. It has Latin-1,
+// Latin Extended, Greek and Cyrillic, a rune outside them is drawn as the
+// empty box of the font. The glyph outlines are filled by the rasterizer
+// like any other path, so the text is anti-aliased at every scale.
+var labelFont = func() *sfnt.Font {
+	f, err := sfnt.Parse(goregular.TTF)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}()
+
+// glyphPath is the outline of the text drawn from the baseline origin at
+// fontPx pixels per em, and its advance width.
+func glyphPath(text string, origin point, fontPx float64) (path, float64) {
+	var buf sfnt.Buffer
+	ppem := fixed.Int26_6(fontPx * 64)
+	var p path
+	x := 0.0
+	prev := sfnt.GlyphIndex(0)
+	for i, r := range text {
+		g, err := labelFont.GlyphIndex(&buf, r)
+		if err != nil {
+			continue
+		}
+		if i > 0 {
+			if kern, err := labelFont.Kern(&buf, prev, g, ppem, font.HintingNone); err == nil {
+				x += fixedFloat(kern)
+			}
+		}
+		prev = g
+		segments, err := labelFont.LoadGlyph(&buf, g, ppem, nil)
+		if err != nil {
+			continue
+		}
+		at := func(v fixed.Point26_6) point {
+			return point{origin.x + x + fixedFloat(v.X), origin.y + fixedFloat(v.Y)}
+		}
+		var last point
+		open := false
+		for _, s := range segments {
+			switch s.Op {
+			case sfnt.SegmentOpMoveTo:
+				if open {
+					p.close()
+				}
+				open = true
+				last = at(s.Args[0])
+				p.moveTo(last.x, last.y)
+			case sfnt.SegmentOpLineTo:
+				last = at(s.Args[0])
+				p.lineTo(last.x, last.y)
+			case sfnt.SegmentOpQuadTo:
+				// the same curve as a cubic one
+				q, to := at(s.Args[0]), at(s.Args[1])
+				p.curveTo(point{last.x + 2*(q.x-last.x)/3, last.y + 2*(q.y-last.y)/3}, point{to.x + 2*(q.x-to.x)/3, to.y + 2*(q.y-to.y)/3}, to)
+				last = to
+			case sfnt.SegmentOpCubeTo:
+				last = at(s.Args[2])
+				p.curveTo(at(s.Args[0]), at(s.Args[1]), last)
+			}
+		}
+		if open {
+			p.close()
+		}
+		if advance, err := labelFont.GlyphAdvance(&buf, g, ppem, font.HintingNone); err == nil {
+			x += fixedFloat(advance)
+		}
+	}
+	return p, x
+}
+
+// glyphTextWidth is the advance width of the text in the label font.
+func glyphTextWidth(text string, fontPx float64) float64 {
+	var buf sfnt.Buffer
+	ppem := fixed.Int26_6(fontPx * 64)
+	width := 0.0
+	prev := sfnt.GlyphIndex(0)
+	for i, r := range text {
+		g, err := labelFont.GlyphIndex(&buf, r)
+		if err != nil {
+			continue
+		}
+		if kern, err := labelFont.Kern(&buf, prev, g, ppem, font.HintingNone); err == nil && i > 0 {
+			width += fixedFloat(kern)
+		}
+		prev = g
+		if advance, err := labelFont.GlyphAdvance(&buf, g, ppem, font.HintingNone); err == nil {
+			width += fixedFloat(advance)
+		}
+	}
+	return width
+}
+
+func fixedFloat(v fixed.Int26_6) float64 {
+	return float64(v) / 64
+}
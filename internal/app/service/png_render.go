@@ -0,0 +1,106 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"go_excelize/internal/app/model"
+	"image/color"
+	"image/png"
+)
+
+// Limits of the PNG output. At scale 1 one scene pixel is one image pixel,
+// which is 96 dpi like the sheet.
+const (
+	minImageScale  = 0.25
+	maxImageScale  = 8.0
+	maxImagePixels = 64 << 20
+	pixelsPerInch  = 96.0
+)
+
+// ImageScale turns a dpi into the scale of RenderPNG.
+func ImageScale(dpi float64) float64 {
+	return dpi / pixelsPerInch
+}
+
+// RenderPNG draws the flowchart as a PNG image, from the same scene as the
+// SVG and the xlsx, scale times the size in pixels of the sheet.
+func (s *ExcelService) RenderPNG(flow *model.Flowchart, scale float64) ([]byte, error) {
+	// written so NaN fails it too
+	if !(scale >= minImageScale && scale <= maxImageScale) {
+		return nil, model.ValidationErrors{{Field: "scale", Message: fmt.Sprintf("must be between %g and %g (%g to %g dpi)",
+			minImageScale, maxImageScale, minImageScale*pixelsPerInch, maxImageScale*pixelsPerInch)}}
+	}
+	sc := layoutScene(flow, defaultOrigin(flow.Layout))
+	view := sc.bounds().inflate(imageMargin)
+	if w, h := view.w*scale, view.h*scale; w*h > maxImagePixels {
+		return nil, model.ValidationErrors{{Field: "scale", Message: fmt.Sprintf("the image would be %.0fx%.0f pixels, use a smaller scale", w, h)}}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, renderPNG(sc, view, scale).img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func renderPNG(sc *scene, view rect, scale float64) *canvas {
+	c := newCanvas(view, scale, color.RGBA{255, 255, 255, 255})
+
+	// back to front, the same layers as the xlsx
+	nodeStroke := nodeLineWidth * pointsToPixels
+	connectorStroke := connectorLineWidth * pointsToPixels
+	black := hexColor(connectorColor)
+	laneStroke := laneLineWidth * pointsToPixels
+	noteStroke := noteLineWidth * pointsToPixels
+	for _, d := range sc.drawables() {
+		switch {
+		case d.lane != nil:
+			l := d.lane
+			for _, part := range []struct {
+				box  rect
+				fill string
+			}{{l.band, l.fill()}, {l.header, laneHeaderColor}} {
+				c.fillPath(rectPath(part.box), hexColor(part.fill))
+				c.strokePath(rectPath(part.box), laneStroke, hexColor(laneLineColor))
+			}
+			c.text(labelLines(l.label, l.header), l.label.size, hexColor(laneFontColor))
+		case d.node != nil:
+			n := d.node
+			fill, line, font := nodeColors(n.node)
+			outline, inner := shapeOutline(n.node.Type, n.box)
+			c.fillPath(outline, hexColor(fill))
+			c.strokePath(outline, nodeStroke, hexColor(line))
+			if inner != nil {
+				c.strokePath(inner, nodeStroke, hexColor(line))
+			}
+			c.text(labelLines(n.label, textArea(n.node.Type, n.box)), n.label.size, hexColor(font))
+		case d.route != nil:
+			if len(d.route.points) < 2 {
+				continue
+			}
+			line, head := arrowLine(d.route.points)
+			c.strokeLine(line, connectorStroke, black)
+			c.fillPolygon(head[:], black)
+		case d.label != nil:
+			c.text(labelLines(d.label.label, d.label.box), d.label.label.size, hexColor(edgeLabelColor))
+		case d.note != nil:
+			n := d.note
+			for _, dash := range dashes(n.link[0], n.link[1]) {
+				c.strokeLine(dash, noteStroke, hexColor(noteLineColor))
+			}
+			c.strokeLine(n.bracket(), noteStroke, hexColor(noteLineColor))
+			c.text(labelLines(n.label, n.box), n.label.size, hexColor(noteFontColor))
+		}
+	}
+	return c
+}
+
+// text draws the lines centered on their x in the label font, size in
+// points.
+func (c *canvas) text(lines []textLine, size float64, col color.RGBA) {
+	fontPx := size * pointsToPixels
+	for _, l := range lines {
+		p, _ := glyphPath(l.text, point{l.x - glyphTextWidth(l.text, fontPx)/2, l.y}, fontPx)
+		c.fillPath(p, col)
+	}
+}
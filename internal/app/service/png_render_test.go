@@ -0,0 +1,22 @@
+package service
+
+import (
+	"go_excelize/internal/app/model"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestRenderPNGScale(t *testing.T) {
+	flow := &model.Flowchart{Nodes: []model.Node{{ID: "a", Type: "P", Label: "Simpan", Column: 1}}}
+	flow.ApplyDefaults()
+	for _, scale := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), 0, 100} {
+		_, err := NewExcelService().RenderPNG(flow, scale)
+		if fields, _ := model.ErrorFields(err); !slices.Equal(fields, []string{"scale"}) {
+			t.Errorf("RenderPNG(scale %g) error = %v, want a scale error", scale, err)
+		}
+	}
+	if _, err := NewExcelService().RenderPNG(flow, 1); err != nil {
+		t.Errorf("RenderPNG(scale 1) error = %v", err)
+	}
+}
@@ -0,0 +1,308 @@
+package service
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// --- A small anti-aliased rasterizer for the PNG output ---
+//
+// Paths are flattened to polygons and filled scanline by scanline with the
+// nonzero rule. Every pixel row is sampled on a few sub-scanlines and every
+// span adds its exact horizontal coverage, which is enough to smooth the
+// edges of shapes, lines and text without an external library.
+
+const (
+	// subScanlines is the vertical samples per pixel row.
+	subScanlines = 4
+	// curveFlatness is the longest straight piece of a flattened curve, in pixels.
+	curveFlatness = 2.0
+)
+
+// canvas draws scene coordinates onto an image: the scene point origin is
+// the top left pixel and one scene pixel is scale image pixels.
+type canvas struct {
+	img    *image.RGBA
+	origin point
+	scale  float64
+	cover  []float64
+}
+
+func newCanvas(view rect, scale float64, background color.RGBA) *canvas {
+	w := int(math.Ceil(view.w * scale))
+	h := int(math.Ceil(view.h * scale))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = background.R, background.G, background.B, background.A
+	}
+	return &canvas{img: img, origin: point{view.x, view.y}, scale: scale, cover: make([]float64, w+2)}
+}
+
+func (c *canvas) toPixels(p point) point {
+	return point{(p.x - c.origin.x) * c.scale, (p.y - c.origin.y) * c.scale}
+}
+
+// fillPath fills the closed subpaths of p.
+func (c *canvas) fillPath(p path, col color.RGBA) {
+	polys, _ := c.flatten(p)
+	c.fill(polys, col)
+}
+
+// strokePath draws the outline of p, width in scene pixels.
+func (c *canvas) strokePath(p path, width float64, col color.RGBA) {
+	polys, closed := c.flatten(p)
+	var outline [][]point
+	for i, poly := range polys {
+		outline = append(outline, strokePolygons(poly, width*c.scale, closed[i])...)
+	}
+	c.fill(outline, col)
+}
+
+// strokeLine draws the open polyline through the scene points.
+func (c *canvas) strokeLine(points []point, width float64, col color.RGBA) {
+	poly := make([]point, len(points))
+	for i, p := range points {
+		poly[i] = c.toPixels(p)
+	}
+	c.fill(strokePolygons(poly, width*c.scale, false), col)
+}
+
+// fillPolygon fills the polygon through the scene points.
+func (c *canvas) fillPolygon(points []point, col color.RGBA) {
+	poly := make([]point, len(points))
+	for i, p := range points {
+		poly[i] = c.toPixels(p)
+	}
+	c.fill([][]point{poly}, col)
+}
+
+// fillRects fills the scene rectangles as one shape, so touching ones blend
+// as a single area.
+func (c *canvas) fillRects(rects []rect, col color.RGBA) {
+	polys := make([][]point, 0, len(rects))
+	for _, r := range rects {
+		a, b := c.toPixels(point{r.x, r.y}), c.toPixels(point{r.right(), r.bottom()})
+		polys = append(polys, []point{{a.x, a.y}, {b.x, a.y}, {b.x, b.y}, {a.x, b.y}})
+	}
+	c.fill(polys, col)
+}
+
+// flatten turns every subpath into a polygon in image pixels, and tells
+// which of them were closed.
+func (c *canvas) flatten(p path) (polys [][]point, closed []bool) {
+	var cur []point
+	flush := func(isClosed bool) {
+		if len(cur) > 1 {
+			polys = append(polys, cur)
+			closed = append(closed, isClosed)
+		}
+		cur = nil
+	}
+	for _, op := range p {
+		switch op.op {
+		case 'M':
+			flush(false)
+			cur = []point{c.toPixels(op.pts[0])}
+		case 'L':
+			cur = append(cur, c.toPixels(op.pts[0]))
+		case 'C':
+			if len(cur) == 0 {
+				continue
+			}
+			p0 := cur[len(cur)-1]
+			p1, p2, p3 := c.toPixels(op.pts[0]), c.toPixels(op.pts[1]), c.toPixels(op.pts[2])
+			length := dist(p0, p1) + dist(p1, p2) + dist(p2, p3)
+			n := max(2, int(math.Ceil(length/curveFlatness)))
+			for i := 1; i <= n; i++ {
+				t := float64(i) / float64(n)
+				u := 1 - t
+				cur = append(cur, point{
+					u*u*u*p0.x + 3*u*u*t*p1.x + 3*u*t*t*p2.x + t*t*t*p3.x,
+					u*u*u*p0.y + 3*u*u*t*p1.y + 3*u*t*t*p2.y + t*t*t*p3.y,
+				})
+			}
+		case 'Z':
+			flush(true)
+		}
+	}
+	flush(false)
+	return polys, closed
+}
+
+func dist(a, b point) float64 { return math.Hypot(b.x-a.x, b.y-a.y) }
+
+// strokePolygons covers a polyline of the given width with a quad per
+// segment and a round join at every corner. They all turn the same way, so
+// filled together with the nonzero rule they make one area and the overlaps
+// are not drawn twice.
+func strokePolygons(poly []point, width float64, closed bool) [][]point {
+	half := width / 2
+	var out [][]point
+	n := len(poly)
+	segments := n - 1
+	if closed {
+		segments = n
+	}
+	for i := 0; i < segments; i++ {
+		a, b := poly[i], poly[(i+1)%n]
+		d := dist(a, b)
+		if d == 0 {
+			continue
+		}
+		nx, ny := -(b.y-a.y)/d*half, (b.x-a.x)/d*half
+		out = append(out, clockwise([]point{
+			{a.x + nx, a.y + ny}, {b.x + nx, b.y + ny}, {b.x - nx, b.y - ny}, {a.x - nx, a.y - ny},
+		}))
+	}
+	for i := 0; i < n; i++ {
+		if !closed && (i == 0 || i == n-1) {
+			continue
+		}
+		out = append(out, disc(poly[i], half))
+	}
+	return out
+}
+
+// disc is a clockwise polygon close enough to a circle at these sizes.
+func disc(c point, r float64) []point {
+	const sides = 12
+	pts := make([]point, sides)
+	for i := range pts {
+		a := 2 * math.Pi * float64(i) / sides
+		pts[i] = point{c.x + r*math.Cos(a), c.y + r*math.Sin(a)}
+	}
+	return pts
+}
+
+// clockwise returns the polygon turning clockwise on screen (y down).
+func clockwise(poly []point) []point {
+	area := 0.0
+	for i, a := range poly {
+		b := poly[(i+1)%len(poly)]
+		area += a.x*b.y - b.x*a.y
+	}
+	if area < 0 {
+		for i, j := 0, len(poly)-1; i < j; i, j = i+1, j-1 {
+			poly[i], poly[j] = poly[j], poly[i]
+		}
+	}
+	return poly
+}
+
+type rasterEdge struct {
+	x0, y0, x1, y1 float64
+	winding        int
+}
+
+type crossing struct {
+	x       float64
+	winding int
+}
+
+// fill paints the polygons (image pixels) with the nonzero rule.
+func (c *canvas) fill(polys [][]point, col color.RGBA) {
+	bounds := c.img.Bounds()
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	var edges []rasterEdge
+	for _, poly := range polys {
+		for i, a := range poly {
+			b := poly[(i+1)%len(poly)]
+			minX, maxX = math.Min(minX, a.x), math.Max(maxX, a.x)
+			minY, maxY = math.Min(minY, a.y), math.Max(maxY, a.y)
+			switch {
+			case a.y < b.y:
+				edges = append(edges, rasterEdge{a.x, a.y, b.x, b.y, 1})
+			case a.y > b.y:
+				edges = append(edges, rasterEdge{b.x, b.y, a.x, a.y, -1})
+			}
+		}
+	}
+	if len(edges) == 0 {
+		return
+	}
+	x0 := max(bounds.Min.X, int(math.Floor(minX)))
+	x1 := min(bounds.Max.X-1, int(math.Floor(maxX)))
+	y0 := max(bounds.Min.Y, int(math.Floor(minY)))
+	y1 := min(bounds.Max.Y-1, int(math.Floor(maxY)))
+	if x0 > x1 || y0 > y1 {
+		return
+	}
+
+	var crossings []crossing
+	for py := y0; py <= y1; py++ {
+		cover := c.cover[x0 : x1+2]
+		for i := range cover {
+			cover[i] = 0
+		}
+		for s := 0; s < subScanlines; s++ {
+			sy := float64(py) + (float64(s)+0.5)/subScanlines
+			crossings = crossings[:0]
+			for _, e := range edges {
+				if sy >= e.y0 && sy < e.y1 {
+					x := e.x0 + (sy-e.y0)*(e.x1-e.x0)/(e.y1-e.y0)
+					crossings = append(crossings, crossing{x, e.winding})
+				}
+			}
+			sort.Slice(crossings, func(i, j int) bool { return crossings[i].x < crossings[j].x })
+			winding := 0
+			start := 0.0
+			for _, cr := range crossings {
+				before := winding
+				winding += cr.winding
+				if before == 0 && winding != 0 {
+					start = cr.x
+				} else if before != 0 && winding == 0 {
+					addSpan(cover, start-float64(x0), cr.x-float64(x0), 1.0/subScanlines)
+				}
+			}
+		}
+		for i, cv := range cover[:x1-x0+1] {
+			if cv > 0 {
+				c.blend(x0+i, py, col, math.Min(cv, 1))
+			}
+		}
+	}
+}
+
+// addSpan adds the part of every pixel the span [a, b) covers, times weight.
+func addSpan(cover []float64, a, b, weight float64) {
+	limit := float64(len(cover) - 1)
+	a, b = math.Max(a, 0), math.Min(b, limit)
+	if b <= a {
+		return
+	}
+	ia, ib := int(a), int(b)
+	if ia == ib {
+		cover[ia] += (b - a) * weight
+		return
+	}
+	cover[ia] += (float64(ia+1) - a) * weight
+	for i := ia + 1; i < ib; i++ {
+		cover[i] += weight
+	}
+	cover[ib] += (b - float64(ib)) * weight
+}
+
+// blend paints col over the pixel with the coverage as alpha.
+func (c *canvas) blend(x, y int, col color.RGBA, coverage float64) {
+	i := c.img.PixOffset(x, y)
+	a := coverage * float64(col.A) / 255
+	pix := c.img.Pix[i : i+4]
+	pix[0] = uint8(math.Round(float64(pix[0])*(1-a) + float64(col.R)*a))
+	pix[1] = uint8(math.Round(float64(pix[1])*(1-a) + float64(col.G)*a))
+	pix[2] = uint8(math.Round(float64(pix[2])*(1-a) + float64(col.B)*a))
+	pix[3] = uint8(math.Round(float64(pix[3])*(1-a) + 255*a))
+}
+
+// hexColor reads an RRGGBB color, black if it is not one.
+func hexColor(hex string) color.RGBA {
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return color.RGBA{A: 255}
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}
+}
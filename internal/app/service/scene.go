@@ -0,0 +1,369 @@
+package service
+
+import (
+	"go_excelize/internal/app/model"
+	"math"
+	"sort"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// scene is the flowchart measured in pixels: the box and label of every node,
+// the routed path of every edge, where its label goes and the notes. The xlsx, SVG, PNG and PDF outputs all
+// draw the same scene, so they always match.
+type scene struct {
+	nodes  []sceneNode
+	routes []routedEdge
+	labels []edgeLabel
+	lanes  []sceneLane
+	notes  []sceneNote
+
+	// the slots of the grid, what the xlsx output resizes the cells to
+	colWidths  []float64
+	rowHeights []float64
+}
+
+type sceneNode struct {
+	node  model.Node
+	box   rect
+	label labelFit
+	rank  int // slot along the flow, the row of the layout turned onto the sheet
+}
+
+// layoutScene places the flowchart with the top left of the start cell at
+// origin. Every column is as wide as its widest shape plus pad, a row with a
+// shape is as high as its highest shape plus pad and a row left empty by the
+// gap keeps the default row height (a column with LR and RL). A shape sits
+// in the middle of its slot. With swimlanes every lane has columns of its
+// own (rows with LR and RL) and the first row holds their headers. A slot
+// across the flow with a note in it starts with the room of the note and
+// its gap, the shapes stay in the middle of the rest.
+func layoutScene(flow *model.Flowchart, origin point) *scene {
+	layout := flow.Layout
+	positions := computeLayout(flow)
+	lanes := flow.LaneNames()
+	var laneSpans [][2]int
+	if len(lanes) > 0 {
+		positions, laneSpans = laneColumns(flow, positions, lanes)
+	}
+	slots := sheetSlots(layout.Direction, positions)
+	turned := across(layout.Direction)
+	pad := float64(layout.Pad)
+	if len(lanes) > 0 {
+		// the headers take the first slot along the flow
+		for id, slot := range slots {
+			if turned {
+				slot.col++
+			} else {
+				slot.row++
+			}
+			slots[id] = slot
+		}
+	}
+
+	sizes := make(map[string]point, len(flow.Nodes))
+	noteSizes := make(map[string]point)
+	cols, rows := 0, 0
+	for _, node := range flow.Nodes {
+		slot := slots[node.ID]
+		cols = max(cols, slot.col+1)
+		rows = max(rows, slot.row+1)
+		if !model.IsBar(node.Type) {
+			w, h := nodeSize(node, layout)
+			sizes[node.ID] = point{w, h}
+		}
+		if node.Note != "" && !model.IsBar(node.Type) {
+			w, h := noteSize(node.Note, layout)
+			noteSizes[node.ID] = point{w, h}
+		}
+	}
+	if n := len(laneSpans); n > 0 && turned {
+		rows = max(rows, laneSpans[n-1][1]+1)
+	} else if n > 0 {
+		cols = max(cols, laneSpans[n-1][1]+1)
+	}
+	sc := &scene{colWidths: make([]float64, cols), rowHeights: make([]float64, rows)}
+	for i := range sc.colWidths {
+		sc.colWidths[i] = float64(layout.Width) + pad
+		if turned {
+			sc.colWidths[i] = defaultColPixels
+		}
+	}
+	for i := range sc.rowHeights {
+		sc.rowHeights[i] = defaultRowPixels
+		if turned {
+			sc.rowHeights[i] = float64(layout.Height) + pad
+		}
+	}
+	if len(lanes) > 0 && turned {
+		sc.colWidths[0] = laneHeaderSize(lanes, layout)
+	} else if len(lanes) > 0 {
+		sc.rowHeights[0] = laneHeaderSize(lanes, layout)
+	}
+	// a column or row is as big as its biggest shape, a slot of bars only
+	// is as thick as a bar along the flow
+	wide := make(map[int]bool)
+	full := make(map[int]bool)
+	for _, node := range flow.Nodes {
+		slot := slots[node.ID]
+		size, ok := sizes[node.ID]
+		if !ok {
+			if turned && !wide[slot.col] {
+				sc.colWidths[slot.col] = barThickness + pad
+			} else if !turned && !full[slot.row] {
+				sc.rowHeights[slot.row] = barThickness + pad
+			}
+			continue
+		}
+		if !wide[slot.col] {
+			sc.colWidths[slot.col] = 0
+		}
+		if !full[slot.row] {
+			sc.rowHeights[slot.row] = 0
+		}
+		wide[slot.col], full[slot.row] = true, true
+		sc.colWidths[slot.col] = math.Max(sc.colWidths[slot.col], size.x+pad)
+		sc.rowHeights[slot.row] = math.Max(sc.rowHeights[slot.row], size.y+pad)
+	}
+	// the room taken by the notes before the shapes of a column (a row with
+	// LR and RL), they are as long as a shape along the flow
+	gap := noteGap(pad)
+	roomX, roomY := make(map[int]float64), make(map[int]float64)
+	for id, size := range noteSizes {
+		slot := slots[id]
+		if turned {
+			roomY[slot.row] = math.Max(roomY[slot.row], size.y+gap)
+			sc.colWidths[slot.col] = math.Max(sc.colWidths[slot.col], size.x+pad)
+		} else {
+			roomX[slot.col] = math.Max(roomX[slot.col], size.x+gap)
+			sc.rowHeights[slot.row] = math.Max(sc.rowHeights[slot.row], size.y+pad)
+		}
+	}
+	for col, room := range roomX {
+		sc.colWidths[col] += room
+	}
+	for row, room := range roomY {
+		sc.rowHeights[row] += room
+	}
+	colLefts := make([]float64, len(sc.colWidths))
+	for col := 1; col < len(colLefts); col++ {
+		colLefts[col] = colLefts[col-1] + sc.colWidths[col-1]
+	}
+	rowTops := make([]float64, len(sc.rowHeights))
+	for row := 1; row < len(rowTops); row++ {
+		rowTops[row] = rowTops[row-1] + sc.rowHeights[row-1]
+	}
+
+	shrink := layout.AutoShrink == nil || *layout.AutoShrink
+	boxes := make(map[string]rect, len(flow.Nodes))
+	for _, node := range flow.Nodes {
+		slot := slots[node.ID]
+		var box rect
+		var label labelFit
+		if size, ok := sizes[node.ID]; ok {
+			box = rect{
+				x: origin.x + colLefts[slot.col] + roomX[slot.col] + (sc.colWidths[slot.col]-roomX[slot.col]-size.x)/2,
+				y: origin.y + rowTops[slot.row] + roomY[slot.row] + (sc.rowHeights[slot.row]-roomY[slot.row]-size.y)/2,
+				w: size.x,
+				h: size.y,
+			}
+			label = fitLabel(node.Label, node.Type, box.w, box.h, layout.FontSize, shrink)
+		} else if first, last := barColumns(flow, node, positions); turned {
+			// across the slots of its branches, half the padding in from
+			// their sides, in the middle of its own slot
+			box.x = origin.x + colLefts[slot.col] + (sc.colWidths[slot.col]-barThickness)/2
+			box.w = barThickness
+			box.y = origin.y + rowTops[first] + roomY[first] + pad/2
+			box.h = rowTops[last] + sc.rowHeights[last] - rowTops[first] - roomY[first] - pad
+		} else {
+			box.x = origin.x + colLefts[first] + roomX[first] + pad/2
+			box.w = colLefts[last] + sc.colWidths[last] - colLefts[first] - roomX[first] - pad
+			box.y = origin.y + rowTops[slot.row] + (sc.rowHeights[slot.row]-barThickness)/2
+			box.h = barThickness
+		}
+		rank := slot.row
+		if turned {
+			rank = slot.col
+		}
+		sc.nodes = append(sc.nodes, sceneNode{node: node, box: box, label: label, rank: rank})
+		boxes[node.ID] = box
+	}
+	// a note ends the gap before the widest shape of its slot, in line with
+	// its shape along the flow
+	for _, n := range sc.nodes {
+		size, ok := noteSizes[n.node.ID]
+		if !ok {
+			continue
+		}
+		slot, c := slots[n.node.ID], n.box.center()
+		note := sceneNote{node: n.node.ID}
+		if turned {
+			bottom := origin.y + rowTops[slot.row] + roomY[slot.row] + pad/2 - gap
+			note.box = rect{c.x - size.x/2, bottom - size.y, size.x, size.y}
+			note.side = sideBottom
+			from := portOn(n.node.Type, n.box, sideTop).at
+			note.link = [2]point{from, {from.x, bottom}}
+		} else {
+			right := origin.x + colLefts[slot.col] + roomX[slot.col] + pad/2 - gap
+			note.box = rect{right - size.x, c.y - size.y/2, size.x, size.y}
+			note.side = sideRight
+			from := portOn(n.node.Type, n.box, sideLeft).at
+			note.link = [2]point{from, {right, from.y}}
+		}
+		note.label = fitLabel(n.node.Note, "rect", note.box.w, note.box.h, layout.FontSize, shrink)
+		sc.notes = append(sc.notes, note)
+		boxes[noteKey(n.node.ID)] = note.box
+	}
+	sc.routes = routeEdges(flow, boxes, pad)
+	sc.labels = placeEdgeLabels(sc.routes, sc.nodes, layout.FontSize)
+
+	// a lane runs the whole length of the chart over its columns
+	chart := rect{origin.x, origin.y, colLefts[len(colLefts)-1] + sc.colWidths[len(colLefts)-1], rowTops[len(rowTops)-1] + sc.rowHeights[len(rowTops)-1]}
+	for i, name := range lanes {
+		first, last := laneSpans[i][0], laneSpans[i][1]
+		band, head := chart, chart
+		if turned {
+			band.y, band.h = origin.y+rowTops[first], rowTops[last]+sc.rowHeights[last]-rowTops[first]
+			head.y, head.h, head.w = band.y, band.h, sc.colWidths[0]
+		} else {
+			band.x, band.w = origin.x+colLefts[first], colLefts[last]+sc.colWidths[last]-colLefts[first]
+			head.x, head.w, head.h = band.x, band.w, sc.rowHeights[0]
+		}
+		sc.lanes = append(sc.lanes, sceneLane{
+			name:   name,
+			band:   band,
+			header: head,
+			label:  fitLabel(name, "rect", head.w, head.h, layout.FontSize, shrink),
+			shaded: i%2 == 0,
+		})
+	}
+	return sc
+}
+
+// nodeSize is the width and height of the shape of the node: its own, or
+// the size of the layout, or with layout.auto_size the size of its label.
+func nodeSize(node model.Node, layout model.Layout) (float64, float64) {
+	w, h := float64(layout.Width), float64(layout.Height)
+	if layout.AutoSize && (node.Width == 0 || node.Height == 0) {
+		w, h = autoSize(node.Label, node.Type, layout.FontSize, w/2, h/2, 2*w)
+	}
+	if node.Width > 0 {
+		w = float64(node.Width)
+	}
+	if node.Height > 0 {
+		h = float64(node.Height)
+	}
+	return w, h
+}
+
+// barThickness is the height in pixels of a fork or join bar.
+const barThickness = 8.0
+
+// barColumns is the first and last column of the layout a bar spans: its
+// own and the ones of the branches, the targets of a fork and the sources
+// of a join. They are rows of the sheet with LR and RL.
+func barColumns(flow *model.Flowchart, bar model.Node, positions map[string]gridPos) (int, int) {
+	first, last := positions[bar.ID].col, positions[bar.ID].col
+	for _, edge := range flow.Edges {
+		other := ""
+		switch {
+		case bar.Type == model.NodeFork && edge.Source == bar.ID:
+			other = edge.Target
+		case bar.Type == model.NodeJoin && edge.Target == bar.ID:
+			other = edge.Source
+		}
+		if pos, ok := positions[other]; ok {
+			first, last = min(first, pos.col), max(last, pos.col)
+		}
+	}
+	return first, last
+}
+
+// layer is the z-order of what the outputs draw: lower layers are drawn
+// first and end up behind. The lines go under the shapes so they never cover
+// a label, a lane background is behind everything.
+type layer int
+
+const (
+	layerLanes       layer = iota // swimlane backgrounds
+	layerConnectors               // routed edges
+	layerNodes                    // shapes with their labels
+	layerEdgeLabels               // text next to the connectors
+	layerAnnotations              // notes of the nodes, on top of everything
+)
+
+// drawable is one thing to draw, exactly one of the pointers is set.
+type drawable struct {
+	layer layer
+	lane  *sceneLane
+	node  *sceneNode
+	route *routedEdge
+	label *edgeLabel
+	note  *sceneNote
+}
+
+// drawables lists everything in the scene from back to front, inside a
+// layer in the order of the document. Every output draws this list, so they
+// stack things the same way.
+func (sc *scene) drawables() []drawable {
+	list := make([]drawable, 0, len(sc.lanes)+len(sc.nodes)+len(sc.routes)+len(sc.labels)+len(sc.notes))
+	for i := range sc.lanes {
+		list = append(list, drawable{layer: layerLanes, lane: &sc.lanes[i]})
+	}
+	for i := range sc.nodes {
+		list = append(list, drawable{layer: layerNodes, node: &sc.nodes[i]})
+	}
+	for i := range sc.routes {
+		list = append(list, drawable{layer: layerConnectors, route: &sc.routes[i]})
+	}
+	for i := range sc.labels {
+		list = append(list, drawable{layer: layerEdgeLabels, label: &sc.labels[i]})
+	}
+	for i := range sc.notes {
+		list = append(list, drawable{layer: layerAnnotations, note: &sc.notes[i]})
+	}
+	sort.SliceStable(list, func(a, b int) bool { return list[a].layer < list[b].layer })
+	return list
+}
+
+// defaultOrigin is where the start cell is on a sheet nobody resized, the
+// image outputs use it so their routes are the ones of a new workbook.
+func defaultOrigin(layout model.Layout) point {
+	col, row, _ := excelize.CellNameToCoordinates(layout.Start)
+	return point{float64(col-1) * defaultColPixels, float64(row-1) * defaultRowPixels}
+}
+
+// bounds is the box around every lane, node, path, edge label and note.
+func (sc *scene) bounds() rect {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	grow := func(p point) {
+		minX, minY = math.Min(minX, p.x), math.Min(minY, p.y)
+		maxX, maxY = math.Max(maxX, p.x), math.Max(maxY, p.y)
+	}
+	for _, n := range sc.nodes {
+		grow(point{n.box.x, n.box.y})
+		grow(point{n.box.right(), n.box.bottom()})
+	}
+	for _, r := range sc.routes {
+		for _, p := range r.points {
+			grow(p)
+		}
+	}
+	for _, l := range sc.labels {
+		grow(point{l.box.x, l.box.y})
+		grow(point{l.box.right(), l.box.bottom()})
+	}
+	for _, l := range sc.lanes {
+		grow(point{l.band.x, l.band.y})
+		grow(point{l.band.right(), l.band.bottom()})
+	}
+	for _, n := range sc.notes {
+		grow(point{n.box.x, n.box.y})
+		grow(point{n.box.right(), n.box.bottom()})
+	}
+	if len(sc.nodes) == 0 {
+		return rect{}
+	}
+	return rect{minX, minY, maxX - minX, maxY - minY}
+}
@@ -0,0 +1,349 @@
+package service
+
+import "math"
+
+// --- Shape outlines for the image outputs ---
+//
+// The xlsx gets the preset name and Excel draws the shape. The SVG, PNG and
+// PDF outputs draw it themselves from these outlines, which follow the
+// presetShapeDefinitions of the same presets.
+
+// pathOp is one step of a path: 'M' move, 'L' line, 'C' cubic curve (three
+// points) or 'Z' close.
+type pathOp struct {
+	op  byte
+	pts []point
+}
+
+type path []pathOp
+
+func (p *path) moveTo(x, y float64) { *p = append(*p, pathOp{'M', []point{{x, y}}}) }
+func (p *path) lineTo(x, y float64) { *p = append(*p, pathOp{'L', []point{{x, y}}}) }
+func (p *path) close()              { *p = append(*p, pathOp{'Z', nil}) }
+
+func (p *path) curveTo(c1, c2, to point) {
+	*p = append(*p, pathOp{'C', []point{c1, c2, to}})
+}
+
+// polygon is a closed path through the points.
+func polygon(points ...point) path {
+	var p path
+	for i, pt := range points {
+		if i == 0 {
+			p.moveTo(pt.x, pt.y)
+		} else {
+			p.lineTo(pt.x, pt.y)
+		}
+	}
+	p.close()
+	return p
+}
+
+// arcTo continues the path along the ellipse centered at c, from the angle
+// start (degrees, clockwise from 3 o'clock like DrawingML) for sweep
+// degrees, as cubic curves of at most 90 degrees each.
+func (p *path) arcTo(c point, rx, ry, start, sweep float64) {
+	segments := int(math.Ceil(math.Abs(sweep) / 90))
+	if segments == 0 {
+		return
+	}
+	step := sweep / float64(segments) * math.Pi / 180
+	k := 4.0 / 3.0 * math.Tan(step/4)
+	theta := start * math.Pi / 180
+	at := func(t float64) point { return point{c.x + rx*math.Cos(t), c.y + ry*math.Sin(t)} }
+	for i := 0; i < segments; i++ {
+		p0, p3 := at(theta), at(theta+step)
+		c1 := point{p0.x - k*rx*math.Sin(theta), p0.y + k*ry*math.Cos(theta)}
+		c2 := point{p3.x + k*rx*math.Sin(theta+step), p3.y - k*ry*math.Cos(theta+step)}
+		p.curveTo(c1, c2, p3)
+		theta += step
+	}
+}
+
+func rectPath(b rect) path {
+	return polygon(point{b.x, b.y}, point{b.right(), b.y}, point{b.right(), b.bottom()}, point{b.x, b.bottom()})
+}
+
+func ellipsePath(b rect) path {
+	var p path
+	c := b.center()
+	p.moveTo(b.right(), c.y)
+	p.arcTo(c, b.w/2, b.h/2, 0, 360)
+	p.close()
+	return p
+}
+
+// roundedRect has corners of rx by ry.
+func roundedRect(b rect, rx, ry float64) path {
+	var p path
+	p.moveTo(b.x+rx, b.y)
+	p.lineTo(b.right()-rx, b.y)
+	p.arcTo(point{b.right() - rx, b.y + ry}, rx, ry, 270, 90)
+	p.lineTo(b.right(), b.bottom()-ry)
+	p.arcTo(point{b.right() - rx, b.bottom() - ry}, rx, ry, 0, 90)
+	p.lineTo(b.x+rx, b.bottom())
+	p.arcTo(point{b.x + rx, b.bottom() - ry}, rx, ry, 90, 90)
+	p.lineTo(b.x, b.y+ry)
+	p.arcTo(point{b.x + rx, b.y + ry}, rx, ry, 180, 90)
+	p.close()
+	return p
+}
+
+// shapeOutline returns the filled outline of the preset in the box, and the
+// lines drawn inside it without fill (the bars of a predefined process...).
+// An unknown preset is drawn as a rectangle.
+func shapeOutline(shapeType string, b rect) (outline, inner path) {
+	x, y, w, h := b.x, b.y, b.w, b.h
+	r, bt := b.right(), b.bottom()
+	c := b.center()
+	ss := math.Min(w, h)
+	switch shapeType {
+	case "ellipse", "flowChartConnector":
+		return ellipsePath(b), nil
+	case "flowChartOr":
+		inner.moveTo(c.x, y)
+		inner.lineTo(c.x, bt)
+		inner.moveTo(x, c.y)
+		inner.lineTo(r, c.y)
+		return ellipsePath(b), inner
+	case "flowChartSummingJunction":
+		dx, dy := w/2*math.Sqrt2/2, h/2*math.Sqrt2/2
+		inner.moveTo(c.x-dx, c.y-dy)
+		inner.lineTo(c.x+dx, c.y+dy)
+		inner.moveTo(c.x+dx, c.y-dy)
+		inner.lineTo(c.x-dx, c.y+dy)
+		return ellipsePath(b), inner
+	case "flowChartDecision", "diamond":
+		return polygon(point{c.x, y}, point{r, c.y}, point{c.x, bt}, point{x, c.y}), nil
+	case "flowChartSort":
+		inner.moveTo(x, c.y)
+		inner.lineTo(r, c.y)
+		return polygon(point{c.x, y}, point{r, c.y}, point{c.x, bt}, point{x, c.y}), inner
+	case "roundRect", "flowChartAlternateProcess":
+		return roundedRect(b, ss/6, ss/6), nil
+	case "flowChartTerminator":
+		return roundedRect(b, w*3475/21600, h/2), nil
+	case "flowChartInputOutput":
+		return polygon(point{x + w/5, y}, point{r, y}, point{r - w/5, bt}, point{x, bt}), nil
+	case "parallelogram":
+		return polygon(point{x + ss/4, y}, point{r, y}, point{r - ss/4, bt}, point{x, bt}), nil
+	case "flowChartDocument":
+		var p path
+		p.moveTo(x, y)
+		p.lineTo(r, y)
+		p.lineTo(r, y+h*17322/21600)
+		p.curveTo(point{x + w/2, y + h*17322/21600}, point{x + w/2, y + h*23922/21600}, point{x, y + h*20172/21600})
+		p.close()
+		return p, nil
+	case "flowChartPredefinedProcess":
+		inner.moveTo(x+w/8, y)
+		inner.lineTo(x+w/8, bt)
+		inner.moveTo(r-w/8, y)
+		inner.lineTo(r-w/8, bt)
+		return polygon(point{x, y}, point{r, y}, point{r, bt}, point{x, bt}), inner
+	case "flowChartInternalStorage":
+		inner.moveTo(x+w/8, y)
+		inner.lineTo(x+w/8, bt)
+		inner.moveTo(x, y+h/8)
+		inner.lineTo(r, y+h/8)
+		return polygon(point{x, y}, point{r, y}, point{r, bt}, point{x, bt}), inner
+	case "flowChartManualOperation":
+		return polygon(point{x, y}, point{r, y}, point{r - w/5, bt}, point{x + w/5, bt}), nil
+	case "flowChartManualInput":
+		return polygon(point{x, y + h/5}, point{r, y}, point{r, bt}, point{x, bt}), nil
+	case "flowChartPreparation":
+		return polygon(point{x + w/5, y}, point{r - w/5, y}, point{r, c.y}, point{r - w/5, bt}, point{x + w/5, bt}, point{x, c.y}), nil
+	case "hexagon":
+		return polygon(point{x + ss/4, y}, point{r - ss/4, y}, point{r, c.y}, point{r - ss/4, bt}, point{x + ss/4, bt}, point{x, c.y}), nil
+	case "flowChartOffpageConnector":
+		return polygon(point{x, y}, point{r, y}, point{r, y + h*4/5}, point{c.x, bt}, point{x, y + h*4/5}), nil
+	case "flowChartPunchedCard":
+		return polygon(point{x + w/5, y}, point{r, y}, point{r, bt}, point{x, bt}, point{x, y + h/5}), nil
+	case "flowChartMerge":
+		return polygon(point{x, y}, point{r, y}, point{c.x, bt}), nil
+	case "flowChartExtract", "triangle":
+		return polygon(point{c.x, y}, point{r, bt}, point{x, bt}), nil
+	case "flowChartCollate":
+		return polygon(point{x, y}, point{r, y}, point{x, bt}, point{r, bt}), nil
+	case "flowChartDelay":
+		var p path
+		p.moveTo(x, y)
+		p.lineTo(c.x, y)
+		p.arcTo(c, w/2, h/2, 270, 180)
+		p.lineTo(x, bt)
+		p.close()
+		return p, nil
+	case "flowChartDisplay":
+		var p path
+		p.moveTo(x, c.y)
+		p.lineTo(x+w/6, y)
+		p.lineTo(r-w/6, y)
+		p.arcTo(point{r - w/6, c.y}, w/6, h/2, 270, 180)
+		p.lineTo(x+w/6, bt)
+		p.close()
+		return p, nil
+	case "flowChartMagneticDisk", "can":
+		ry := h / 6
+		var p path
+		p.moveTo(x, y+ry)
+		p.arcTo(point{c.x, y + ry}, w/2, ry, 180, 180)
+		p.lineTo(r, bt-ry)
+		p.arcTo(point{c.x, bt - ry}, w/2, ry, 0, 180)
+		p.close()
+		inner.moveTo(r, y+ry)
+		inner.arcTo(point{c.x, y + ry}, w/2, ry, 0, 180)
+		return p, inner
+	default:
+		return polygon(point{x, y}, point{r, y}, point{r, bt}, point{x, bt}), nil
+	}
+}
+
+// shapePort is a connection port of a preset, a point on its outline that
+// lines leave and enter across side. site is the index of the connection
+// site of the preset there, a connector glued to it follows the shape.
+type shapePort struct {
+	name string
+	at   point
+	side side
+	site int
+}
+
+// sideNames are the names of the ports in the middle of each side.
+var sideNames = [4]string{sideTop: "top", sideRight: "right", sideBottom: "bottom", sideLeft: "left"}
+
+// shapePorts returns the connection ports of the preset in the box, the four
+// sides first and then the extra ones, with the sites of the cxnLst in
+// presetShapeDefinitions. The rect-like presets have their sides in the
+// middle of the box and list top, left, bottom and right, the slanted and
+// curved ones move them onto the outline.
+func shapePorts(shapeType string, b rect) []shapePort {
+	x, r, c := b.x, b.right(), b.center()
+	ss := math.Min(b.w, b.h)
+	sides := func(top, left, bottom, right point) []shapePort {
+		return []shapePort{
+			{"top", top, sideTop, 0},
+			{"left", left, sideLeft, 1},
+			{"bottom", bottom, sideBottom, 2},
+			{"right", right, sideRight, 3},
+		}
+	}
+	// the sides moved in to the middle of a slanted edge
+	slanted := func(inset float64) []shapePort {
+		return sides(b.port(sideTop), point{x + inset, c.y}, b.port(sideBottom), point{r - inset, c.y})
+	}
+	switch shapeType {
+	case "ellipse", "flowChartConnector", "flowChartOr", "flowChartSummingJunction":
+		// a site every eighth turn, from the top counterclockwise
+		dx, dy := b.w/2*math.Sqrt2/2, b.h/2*math.Sqrt2/2
+		return []shapePort{
+			{"top", b.port(sideTop), sideTop, 0},
+			{"left", b.port(sideLeft), sideLeft, 2},
+			{"bottom", b.port(sideBottom), sideBottom, 4},
+			{"right", b.port(sideRight), sideRight, 6},
+			{"top-left", point{c.x - dx, c.y - dy}, sideTop, 1},
+			{"bottom-left", point{c.x - dx, c.y + dy}, sideBottom, 3},
+			{"bottom-right", point{c.x + dx, c.y + dy}, sideBottom, 5},
+			{"top-right", point{c.x + dx, c.y - dy}, sideTop, 7},
+		}
+	case "flowChartInputOutput", "flowChartManualOperation":
+		return slanted(b.w / 10)
+	case "parallelogram":
+		return slanted(ss / 8)
+	case "flowChartExtract", "flowChartMerge":
+		return slanted(b.w / 4)
+	case "triangle":
+		return []shapePort{
+			{"top", b.port(sideTop), sideTop, 0},
+			{"left", point{x + b.w/4, c.y}, sideLeft, 1},
+			{"bottom", b.port(sideBottom), sideBottom, 3},
+			{"right", point{r - b.w/4, c.y}, sideRight, 5},
+			{"bottom-left", point{x, b.bottom()}, sideBottom, 2},
+			{"bottom-right", point{r, b.bottom()}, sideBottom, 4},
+		}
+	case "flowChartDocument":
+		// the bottom is on the wave, not the box
+		return sides(b.port(sideTop), b.port(sideLeft), point{c.x, b.y + b.h*20172/21600}, b.port(sideRight))
+	case "flowChartManualInput":
+		return sides(point{c.x, b.y + b.h/10}, b.port(sideLeft), b.port(sideBottom), b.port(sideRight))
+	default:
+		return sides(b.port(sideTop), b.port(sideLeft), b.port(sideBottom), b.port(sideRight))
+	}
+}
+
+// portOn is the port of the preset in the middle of the side.
+func portOn(shapeType string, b rect, s side) shapePort {
+	for _, p := range shapePorts(shapeType, b) {
+		if p.name == sideNames[s] {
+			return p
+		}
+	}
+	return shapePort{sideNames[s], b.port(s), s, 0}
+}
+
+// textArea is the part of the box the label is centered in, the text rect of
+// the preset (see textAreas).
+func textArea(shapeType string, b rect) rect {
+	area, ok := textAreas[shapeType]
+	if !ok {
+		area = [2]float64{1, 1}
+	}
+	w, h := b.w*area[0], b.h*area[1]
+	t := rect{b.center().x - w/2, b.center().y - h/2, w, h}
+	// the wavy or pointed bottom is left out of the text rect, and the top
+	// of a cylinder
+	switch shapeType {
+	case "flowChartDocument", "flowChartOffpageConnector":
+		t.y = b.y
+	case "flowChartMagneticDisk", "can":
+		t.y = b.bottom() - t.h
+	}
+	return t
+}
+
+// textLine is a line of a label, centered on x with its baseline at y.
+type textLine struct {
+	text string
+	x, y float64
+}
+
+// labelLines places the lines of the label in the middle of the text area.
+func labelLines(label labelFit, area rect) []textLine {
+	lh := lineHeight(label.size)
+	fontPx := label.size * pointsToPixels
+	top := area.center().y - float64(len(label.lines))*lh/2
+	lines := make([]textLine, 0, len(label.lines))
+	for i, text := range label.lines {
+		// the baseline sits about 0.8 em below the top of the glyphs
+		baseline := top + float64(i)*lh + (lh-fontPx)/2 + 0.8*fontPx
+		lines = append(lines, textLine{text: text, x: area.center().x, y: baseline})
+	}
+	return lines
+}
+
+// arrowHead is the triangle at the end of a path, tip on the last point.
+// The size is the "med" arrow Excel draws on a 1.5pt line.
+func arrowHead(points []point) [3]point {
+	const length, halfWidth = 9.0, 4.5
+	tip, from := points[len(points)-1], points[len(points)-2]
+	dx, dy := tip.x-from.x, tip.y-from.y
+	d := math.Hypot(dx, dy)
+	if d == 0 {
+		return [3]point{tip, tip, tip}
+	}
+	ux, uy := dx/d, dy/d
+	base := point{tip.x - ux*length, tip.y - uy*length}
+	return [3]point{
+		tip,
+		{base.x - uy*halfWidth, base.y + ux*halfWidth},
+		{base.x + uy*halfWidth, base.y - ux*halfWidth},
+	}
+}
+
+// arrowLine is the path cut back into its arrowhead, so a wide line does not
+// poke out of the tip, and the arrowhead.
+func arrowLine(points []point) ([]point, [3]point) {
+	head := arrowHead(points)
+	line := append([]point(nil), points...)
+	line[len(line)-1] = point{(head[1].x + head[2].x) / 2, (head[1].y + head[2].y) / 2}
+	return line, head
+}
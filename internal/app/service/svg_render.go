@@ -0,0 +1,111 @@
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"go_excelize/internal/app/model"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// imageMargin is the white space around the chart in the image outputs.
+const imageMargin = 20.0
+
+// RenderSVG draws the flowchart as an SVG image. It is the scene of a new
+// workbook, so the image and the xlsx have the same boxes and routes.
+func (s *ExcelService) RenderSVG(flow *model.Flowchart) ([]byte, error) {
+	return renderSVG(layoutScene(flow, defaultOrigin(flow.Layout))), nil
+}
+
+func renderSVG(sc *scene) []byte {
+	view := sc.bounds().inflate(imageMargin)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%s" height="%s" viewBox="%s %s %s %s">`+"\n",
+		svgNum(view.w), svgNum(view.h), svgNum(view.x), svgNum(view.y), svgNum(view.w), svgNum(view.h))
+	fmt.Fprintf(&buf, `<rect x="%s" y="%s" width="%s" height="%s" fill="#FFFFFF"/>`+"\n",
+		svgNum(view.x), svgNum(view.y), svgNum(view.w), svgNum(view.h))
+
+	// back to front, the same layers as the xlsx
+	nodeStroke := svgNum(nodeLineWidth * pointsToPixels)
+	connectorStroke := svgNum(connectorLineWidth * pointsToPixels)
+	laneStroke := svgNum(laneLineWidth * pointsToPixels)
+	noteStroke := svgNum(noteLineWidth * pointsToPixels)
+	for _, d := range sc.drawables() {
+		switch {
+		case d.lane != nil:
+			l := d.lane
+			fmt.Fprintf(&buf, `<path d="%s" fill="#%s" stroke="#%s" stroke-width="%s"/>`+"\n", svgPath(rectPath(l.band)), l.fill(), laneLineColor, laneStroke)
+			fmt.Fprintf(&buf, `<path d="%s" fill="#%s" stroke="#%s" stroke-width="%s"/>`+"\n", svgPath(rectPath(l.header)), laneHeaderColor, laneLineColor, laneStroke)
+			svgText(&buf, labelLines(l.label, l.header), l.label.size, laneFontColor)
+		case d.node != nil:
+			n := d.node
+			fill, line, font := nodeColors(n.node)
+			outline, inner := shapeOutline(n.node.Type, n.box)
+			fmt.Fprintf(&buf, `<path d="%s" fill="#%s" stroke="#%s" stroke-width="%s"/>`+"\n", svgPath(outline), fill, line, nodeStroke)
+			if inner != nil {
+				fmt.Fprintf(&buf, `<path d="%s" fill="none" stroke="#%s" stroke-width="%s"/>`+"\n", svgPath(inner), line, nodeStroke)
+			}
+			svgText(&buf, labelLines(n.label, textArea(n.node.Type, n.box)), n.label.size, font)
+		case d.route != nil:
+			r := d.route
+			if len(r.points) < 2 {
+				continue
+			}
+			pts := make([]string, len(r.points))
+			for i, p := range r.points {
+				pts[i] = svgNum(p.x) + "," + svgNum(p.y)
+			}
+			fmt.Fprintf(&buf, `<polyline points="%s" fill="none" stroke="#%s" stroke-width="%s"/>`+"\n", strings.Join(pts, " "), connectorColor, connectorStroke)
+			head := arrowHead(r.points)
+			fmt.Fprintf(&buf, `<polygon points="%s,%s %s,%s %s,%s" fill="#%s"/>`+"\n",
+				svgNum(head[0].x), svgNum(head[0].y), svgNum(head[1].x), svgNum(head[1].y), svgNum(head[2].x), svgNum(head[2].y), connectorColor)
+		case d.label != nil:
+			svgText(&buf, labelLines(d.label.label, d.label.box), d.label.label.size, edgeLabelColor)
+		case d.note != nil:
+			n := d.note
+			fmt.Fprintf(&buf, `<line x1="%s" y1="%s" x2="%s" y2="%s" stroke="#%s" stroke-width="%s" stroke-dasharray="%s"/>`+"\n",
+				svgNum(n.link[0].x), svgNum(n.link[0].y), svgNum(n.link[1].x), svgNum(n.link[1].y), noteLineColor, noteStroke, svgNum(noteDash))
+			fmt.Fprintf(&buf, `<path d="%s" fill="none" stroke="#%s" stroke-width="%s"/>`+"\n", svgPath(openPath(n.bracket())), noteLineColor, noteStroke)
+			svgText(&buf, labelLines(n.label, n.box), n.label.size, noteFontColor)
+		}
+	}
+	buf.WriteString("</svg>\n")
+	return buf.Bytes()
+}
+
+// svgText writes the lines centered on their x, size in points.
+func svgText(buf *bytes.Buffer, lines []textLine, size float64, color string) {
+	for _, l := range lines {
+		fmt.Fprintf(buf, `<text x="%s" y="%s" font-family="%s" font-size="%spt" fill="#%s" text-anchor="middle">%s</text>`+"\n",
+			svgNum(l.x), svgNum(l.y), nodeFont, svgNum(size), color, xmlText(l.text))
+	}
+}
+
+// svgPath writes the path as SVG path data.
+func svgPath(p path) string {
+	var b strings.Builder
+	for _, op := range p {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteByte(op.op)
+		for _, pt := range op.pts {
+			b.WriteString(" " + svgNum(pt.x) + "," + svgNum(pt.y))
+		}
+	}
+	return b.String()
+}
+
+// svgNum rounds to two decimals and drops the trailing zeros.
+func svgNum(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+// xmlText escapes the text for XML.
+func xmlText(text string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(text))
+	return b.String()
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"go_excelize/internal/app/model"
+	"math"
+	"slices"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Look of the swimlanes, shared by every output.
+const (
+	laneLineColor   = "A6A6A6"
+	laneFillColor   = "F2F2F2" // every other lane, starting with the first
+	laneHeaderColor = "D9E1F2"
+	laneFontColor   = "000000"
+	laneLineWidth   = 0.75 // points
+)
+
+// sceneLane is the band of a swimlane across the chart and its header cell
+// at the start of the band, the top of a lane running down and the left end
+// of one running across.
+type sceneLane struct {
+	name   string
+	band   rect
+	header rect
+	label  labelFit
+	shaded bool
+}
+
+// fill is the background of the band.
+func (l *sceneLane) fill() string {
+	if l.shaded {
+		return laneFillColor
+	}
+	return nodeFillColor
+}
+
+// down tells if the lane runs down the sheet, with its header on top.
+func (l *sceneLane) down() bool {
+	return l.header.w == l.band.w
+}
+
+// clipped is the part of the lane from start to end along its length, y
+// for a lane running down and x for one running across, for a page of the
+// chart. The lane keeps its header right before start.
+func (l sceneLane) clipped(start, end float64) (sceneLane, bool) {
+	if l.down() {
+		y0, y1 := math.Max(l.band.y, start-l.header.h), math.Min(l.band.bottom(), end)
+		if y1 <= y0 {
+			return l, false
+		}
+		l.band.y, l.band.h = y0, y1-y0
+		l.header.y = y0
+		return l, true
+	}
+	x0, x1 := math.Max(l.band.x, start-l.header.w), math.Min(l.band.right(), end)
+	if x1 <= x0 {
+		return l, false
+	}
+	l.band.x, l.band.w = x0, x1-x0
+	l.header.x = x0
+	return l, true
+}
+
+// laneColumns gives every lane columns of its own, next to each other in
+// the order of the lanes. A lane keeps the columns its nodes have in the
+// layout, in the same order without the ones it does not use, and a lane
+// with no node gets one empty column. A node without an actor (a bar, or
+// an end terminator after one) goes in the column of the first node it is
+// connected to, a bar then spans its branches from there. The spans are the
+// first and last column of every lane.
+func laneColumns(flow *model.Flowchart, positions map[string]gridPos, lanes []string) (map[string]gridPos, [][2]int) {
+	used := make(map[string][]int, len(lanes))
+	for _, node := range flow.Nodes {
+		if node.Actor != "" && !slices.Contains(used[node.Actor], positions[node.ID].col) {
+			used[node.Actor] = append(used[node.Actor], positions[node.ID].col)
+		}
+	}
+	moved := make(map[string]gridPos, len(positions))
+	spans := make([][2]int, len(lanes))
+	next := 0
+	for i, lane := range lanes {
+		cols := used[lane]
+		slices.Sort(cols)
+		for _, node := range flow.Nodes {
+			if node.Actor == lane {
+				pos := positions[node.ID]
+				moved[node.ID] = gridPos{col: next + slices.Index(cols, pos.col), row: pos.row}
+			}
+		}
+		spans[i] = [2]int{next, next + max(len(cols), 1) - 1}
+		next += max(len(cols), 1)
+	}
+	for _, node := range flow.Nodes {
+		if node.Actor != "" {
+			continue
+		}
+		col := 0
+		for _, edge := range flow.Edges {
+			other := ""
+			if edge.Source == node.ID {
+				other = edge.Target
+			} else if edge.Target == node.ID {
+				other = edge.Source
+			}
+			if pos, ok := moved[other]; ok {
+				col = pos.col
+				break
+			}
+		}
+		moved[node.ID] = gridPos{col: col, row: positions[node.ID].row}
+	}
+	return moved, spans
+}
+
+// laneHeaderSize is how deep the header slot is along the flow: a line of
+// text for lanes running down, the longest lane name for lanes running
+// across, never wider than a shape.
+func laneHeaderSize(lanes []string, layout model.Layout) float64 {
+	if !across(layout.Direction) {
+		return math.Ceil(lineHeight(layout.FontSize) + 4*textInsetY)
+	}
+	em := layout.FontSize * pointsToPixels
+	widest := 0.0
+	for _, name := range lanes {
+		widest = math.Max(widest, textWidth(name, layout.FontSize))
+	}
+	return math.Min(math.Ceil(widest+em+2*textInsetX), float64(layout.Width))
+}
+
+// laneShape is a rectangle of a lane on the sheet, its band or its header.
+func laneShape(cell string, box rect, label labelFit, fill string, offsetX, offsetY int) *excelize.Shape {
+	shape := newFlowchartShape(cell, "rect", label, uint(box.w), uint(box.h), offsetX, offsetY)
+	lineWidth := laneLineWidth
+	shape.Fill.Color = []string{fill}
+	shape.Line = excelize.ShapeLine{Color: laneLineColor, Width: &lineWidth}
+	for i := range shape.Paragraph {
+		shape.Paragraph[i].Font.Color = laneFontColor
+	}
+	return shape
+}
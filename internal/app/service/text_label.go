@@ -0,0 +1,185 @@
+package service
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+const (
+	minFontSize    = 7.0
+	lineSpacing    = 1.2
+	textInsetX     = 9.6 // default lIns/rIns of a shape, 0.1 inch in pixels
+	textInsetY     = 4.8 // default tIns/bIns of a shape, 0.05 inch in pixels
+	pointsToPixels = 4.0 / 3.0
+)
+
+// textAreas is the part of the shape box the preset keeps for text, as
+// fractions of the width and height (from the presetShapeDefinitions text rects).
+var textAreas = map[string][2]float64{
+	"flowChartDecision":          {0.5, 0.5},
+	"ellipse":                    {0.7, 0.7},
+	"flowChartConnector":         {0.7, 0.7},
+	"flowChartInputOutput":       {0.6, 1},
+	"parallelogram":              {0.6, 1},
+	"flowChartDocument":          {1, 0.8},
+	"flowChartPredefinedProcess": {0.75, 1},
+	"flowChartManualOperation":   {0.6, 1},
+	"flowChartPreparation":       {0.6, 1},
+	"flowChartDisplay":           {0.7, 1},
+	"flowChartOffpageConnector":  {1, 0.8},
+	"flowChartTerminator":        {0.9, 1},
+	"flowChartMagneticDisk":      {1, 2.0 / 3},
+	"can":                        {1, 2.0 / 3},
+}
+
+// labelFit is a label broken into lines at the font size that fits the shape.
+type labelFit struct {
+	lines []string
+	size  float64
+}
+
+// fitLabel wraps the label to the text area of the shape. With shrink the font
+// size goes down until every line fits, but never below minFontSize.
+func fitLabel(label, shapeType string, width, height, size float64, shrink bool) labelFit {
+	area, ok := textAreas[shapeType]
+	if !ok {
+		area = [2]float64{1, 1}
+	}
+	areaWidth := width*area[0] - 2*textInsetX
+	areaHeight := height*area[1] - 2*textInsetY
+
+	for {
+		lines := wrapText(label, areaWidth, size)
+		if !shrink || size <= minFontSize || labelFits(label, lines, areaWidth, areaHeight, size) {
+			return labelFit{lines: lines, size: size}
+		}
+		size -= 0.5
+	}
+}
+
+// autoSize is the size of the shape that holds the label at the font size
+// without shrinking it. The label stays on one line up to maxWidth, a longer
+// one is wrapped and the shape grows in height. An em is left on top of the
+// text so it does not touch the outline. The size is never below minWidth
+// by minHeight.
+func autoSize(label, shapeType string, size, minWidth, minHeight, maxWidth float64) (float64, float64) {
+	area, ok := textAreas[shapeType]
+	if !ok {
+		area = [2]float64{1, 1}
+	}
+	em := size * pointsToPixels
+	lines := wrapText(label, maxWidth*area[0]-2*textInsetX-em, size)
+	textW := em
+	for _, line := range lines {
+		textW = math.Max(textW, textWidth(line, size)+em)
+	}
+	w := math.Ceil((textW + 2*textInsetX) / area[0])
+	h := math.Ceil((float64(len(lines))*lineHeight(size) + 2*textInsetY) / area[1])
+	return math.Max(w, minWidth), math.Max(h, minHeight)
+}
+
+// labelFits tells if the wrapped lines fit the area without cutting a word.
+func labelFits(label string, lines []string, width, height, size float64) bool {
+	if float64(len(lines))*lineHeight(size) > height {
+		return false
+	}
+	for _, word := range strings.Fields(label) {
+		if textWidth(word, size) > width {
+			return false
+		}
+	}
+	return true
+}
+
+// lineHeight in pixels of a line of text at the font size in points.
+func lineHeight(size float64) float64 {
+	return size * pointsToPixels * lineSpacing
+}
+
+// wrapText breaks the text into lines not wider than maxWidth pixels. Words
+// are kept whole unless a single word is wider than the line. A line
+// narrower than one rune (or with no room at all) still takes one rune.
+func wrapText(text string, maxWidth, size float64) []string {
+	// below zero even the empty rest of a broken word would not fit
+	maxWidth = math.Max(maxWidth, 0)
+	var lines []string
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		var line string
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if textWidth(candidate, size) <= maxWidth {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			// the word alone is too long, break it between runes
+			// the last piece starts the next line, even when it is one
+			// rune wider than the line
+			for textWidth(word, size) > maxWidth {
+				cut := breakWord(word, maxWidth, size)
+				if cut == len(word) {
+					break
+				}
+				lines = append(lines, word[:cut])
+				word = word[cut:]
+			}
+			line = word
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// breakWord returns the byte index where the word has to be cut to fit, at
+// least one rune so the loop always moves on.
+func breakWord(word string, maxWidth, size float64) int {
+	width := 0.0
+	for i, r := range word {
+		width += runeWidth(r) * size * pointsToPixels
+		if width > maxWidth && i > 0 {
+			return i
+		}
+	}
+	return len(word)
+}
+
+// textWidth estimates the width in pixels of the text at the font size in
+// points. It is an average of Times New Roman, good enough to wrap lines.
+func textWidth(text string, size float64) float64 {
+	width := 0.0
+	for _, r := range text {
+		width += runeWidth(r)
+	}
+	return width * size * pointsToPixels
+}
+
+// runeWidth is the advance width of a rune in em.
+func runeWidth(r rune) float64 {
+	switch {
+	case r == ' ':
+		return 0.25
+	case strings.ContainsRune("iljI.,:;'!|", r):
+		return 0.28
+	case strings.ContainsRune("ftr()[]-", r):
+		return 0.35
+	case strings.ContainsRune("mwMW", r):
+		return 0.9
+	case unicode.Is(unicode.Mn, r):
+		return 0 // combining marks sit on the previous letter
+	case r >= 0x1100 && (unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hangul, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r)):
+		return 1
+	case unicode.IsUpper(r):
+		return 0.68
+	case unicode.IsDigit(r) || unicode.IsLower(r):
+		return 0.5
+	default:
+		return 0.55
+	}
+}
@@ -0,0 +1,23 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrapTextNarrow(t *testing.T) {
+	for _, maxWidth := range []float64{-20, 0, 1} {
+		done := make(chan []string, 1)
+		go func() { done <- wrapText("Isi formulir", maxWidth, 11) }()
+		select {
+		case lines := <-done:
+			// narrower than one rune, every rune still takes a line
+			if got := strings.Join(lines, ""); got != "Isiformulir" || len(lines) != len("Isiformulir") {
+				t.Errorf("wrapText(maxWidth %g) = %q, want one rune a line", maxWidth, lines)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("wrapText(maxWidth %g) does not return", maxWidth)
+		}
+	}
+}